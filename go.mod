@@ -3,6 +3,8 @@ module github.com/datawire/layertool
 go 1.15
 
 require (
+	github.com/datawire/dlib v1.2.0
 	github.com/google/go-containerregistry v0.3.0
-	github.com/stretchr/testify v1.5.1
+	github.com/klauspost/compress v1.11.7
+	github.com/stretchr/testify v1.6.1
 )
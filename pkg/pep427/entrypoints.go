@@ -0,0 +1,81 @@
+package pep427
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Entry point groups that installers generate launcher scripts for.  On POSIX, launchers for both
+// groups are the same; on Windows, gui_scripts get a launcher that doesn't open a console.
+const (
+	GroupConsoleScripts = "console_scripts"
+	GroupGUIScripts     = "gui_scripts"
+)
+
+// An EntryPoint is a single `name = module:attr [extras]` line from an `entry_points.txt` file.
+type EntryPoint struct {
+	Name   string
+	Module string   // "package.module"
+	Attr   string   // "object.attribute", or empty to refer to the module itself
+	Extras []string // nil if there are no extras
+}
+
+// EntryPoints is the parsed form of an `entry_points.txt` file; it maps each group name to the
+// entry points in that group, in the order that they appear in the file.
+type EntryPoints map[string][]EntryPoint
+
+// These mimic `importlib.metadata.EntryPoint.pattern` and `.extras`; "\w" in a Python 3 `str`
+// pattern is Unicode-aware.
+var (
+	reObjectReference = regexp.MustCompile(`^([\p{L}\p{N}_.]+)\s*(?::\s*([\p{L}\p{N}_.]+)\s*)?(?:(\[.*\])\s*)?$`)
+	reExtra           = regexp.MustCompile(`[\p{L}\p{N}_]+`)
+)
+
+// ParseEntryPoints parses an `entry_points.txt` file.
+//
+// https://packaging.python.org/specifications/entry-points/
+//
+// This is based off of `importlib.metadata` (`Sectioned` and `EntryPoint`), which is more lenient
+// than the spec's "use configparser": leading and trailing whitespace is ignored, lines starting
+// with "#" are comments, and lines before the first `[group]` header are ignored.
+func ParseEntryPoints(r io.Reader) (EntryPoints, error) {
+	ret := make(EntryPoints)
+	group, inGroup := "", false
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			group, inGroup = strings.Trim(line, "[]"), true
+			continue
+		case !inGroup:
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("entry_points.txt: line %d: missing \"=\": %q", lineno, line)
+		}
+		ep := EntryPoint{
+			Name: strings.TrimSpace(line[:eq]),
+		}
+		value := strings.TrimSpace(line[eq+1:])
+		match := reObjectReference.FindStringSubmatch(value)
+		if match == nil {
+			return nil, fmt.Errorf("entry_points.txt: line %d: invalid object reference: %q", lineno, value)
+		}
+		ep.Module = match[1]
+		ep.Attr = match[2]
+		ep.Extras = reExtra.FindAllString(match[3], -1)
+		ret[group] = append(ret[group], ep)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("entry_points.txt: %w", err)
+	}
+	return ret, nil
+}
@@ -0,0 +1,76 @@
+package pep427_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/layertool/pkg/pep427"
+)
+
+func TestParseEntryPoints(t *testing.T) {
+	t.Parallel()
+	testcases := map[string]struct {
+		Input          string
+		ExpectedOutput pep427.EntryPoints
+		ExpectedErr    string
+	}{
+		"realistic": {
+			Input: `
+# ignored: not in a group
+stray = pkg:main
+
+[console_scripts]
+foo = foo.cli:main
+foo-admin=foo.admin : run
+foo-extra = foo.cli:main [ssl, socks]
+
+[gui_scripts]
+  foo-gui = foo.gui.app:App.run
+
+[foo.plugins]
+# comments are ignored
+bar = foo.plugins.bar
+`,
+			ExpectedOutput: pep427.EntryPoints{
+				pep427.GroupConsoleScripts: {
+					{Name: "foo", Module: "foo.cli", Attr: "main"},
+					{Name: "foo-admin", Module: "foo.admin", Attr: "run"},
+					{Name: "foo-extra", Module: "foo.cli", Attr: "main", Extras: []string{"ssl", "socks"}},
+				},
+				pep427.GroupGUIScripts: {
+					{Name: "foo-gui", Module: "foo.gui.app", Attr: "App.run"},
+				},
+				"foo.plugins": {
+					{Name: "bar", Module: "foo.plugins.bar"},
+				},
+			},
+		},
+		"empty": {
+			Input:          "",
+			ExpectedOutput: pep427.EntryPoints{},
+		},
+		"missing-equals": {
+			Input:       "[console_scripts]\nfoo foo.cli:main\n",
+			ExpectedErr: `entry_points.txt: line 2: missing "=": "foo foo.cli:main"`,
+		},
+		"bad-reference": {
+			Input:       "[console_scripts]\nfoo = foo/cli.py\n",
+			ExpectedErr: `entry_points.txt: line 2: invalid object reference: "foo/cli.py"`,
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			actual, err := pep427.ParseEntryPoints(strings.NewReader(tc.Input))
+			if tc.ExpectedErr != "" {
+				assert.EqualError(t, err, tc.ExpectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.ExpectedOutput, actual)
+		})
+	}
+}
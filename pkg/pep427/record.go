@@ -0,0 +1,93 @@
+package pep427
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A recordRow is a row of a `.dist-info/RECORD` file: a path, "sha256=…" hash, and size.  For
+// rows that don't have a hash or size, those are empty strings.
+type recordRow [3]string
+
+func parseRecord(filename string, body []byte) ([]recordRow, error) {
+	csvReader := csv.NewReader(bytes.NewReader(body))
+	csvReader.FieldsPerRecord = -1
+	rows, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", filename, err)
+	}
+	ret := make([]recordRow, 0, len(rows))
+	for _, row := range rows {
+		var rrow recordRow
+		copy(rrow[:], row)
+		ret = append(ret, rrow)
+	}
+	return ret, nil
+}
+
+// writeRecord serializes a RECORD file the way pip does: sorted, with "\r\n" line endings.
+func writeRecord(rows []recordRow) ([]byte, error) {
+	sorted := append([]recordRow(nil), rows...)
+	sort.Slice(sorted, func(i, j int) bool {
+		for k := range sorted[i] {
+			if sorted[i][k] != sorted[j][k] {
+				return sorted[i][k] < sorted[j][k]
+			}
+		}
+		return false
+	})
+
+	var buf bytes.Buffer
+	csvWriter := csv.NewWriter(&buf)
+	csvWriter.UseCRLF = true
+	for _, row := range sorted {
+		if err := csvWriter.Write(row[:]); err != nil {
+			return nil, err
+		}
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// recordHash returns the hash and size fields of a RECORD row for a file with the given content.
+//
+// This is based off of `pip/_internal/operations/install/wheel.py:rehash()`.
+func recordHash(body []byte) (hash, size string) {
+	sum := sha256.Sum256(body)
+	return "sha256=" + base64.RawURLEncoding.EncodeToString(sum[:]), strconv.Itoa(len(body))
+}
+
+// recordPath returns the name of an installed file as written in RECORD: relative to the
+// directory that the root of the wheel was installed in to, even if that means using "..".
+//
+// This is based off of `pip/_internal/operations/install/wheel.py:_fs_to_record_path()`.
+func recordPath(rootDir, filename string) string {
+	split := func(p string) []string {
+		p = layerPath(p)
+		if p == "" {
+			return nil
+		}
+		return strings.Split(p, "/")
+	}
+	base, target := split(rootDir), split(filename)
+	common := 0
+	for common < len(base) && common < len(target) && base[common] == target[common] {
+		common++
+	}
+	parts := make([]string, 0, len(base)-common+len(target)-common)
+	for i := common; i < len(base); i++ {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, target[common:]...)
+	return path.Join(parts...)
+}
@@ -0,0 +1,67 @@
+package pep427
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// schemeScript prints the interpreter's default install scheme as JSON.  It sticks to things that
+// exist in Python 2.7 too.
+const schemeScript = `
+import json, sys, sysconfig
+paths = sysconfig.get_paths()
+json.dump({
+    "python":  sys.executable,
+    "purelib": paths["purelib"],
+    "platlib": paths["platlib"],
+    "include": paths["include"],
+    "scripts": paths["scripts"],
+    "data":    paths["data"],
+}, sys.stdout)
+`
+
+// SchemeFromInterpreter runs a Python interpreter and asks it for its default install scheme
+// (`sysconfig.get_paths()`), so that wheels get installed to the same places that pip running
+// under that interpreter would put them.
+//
+// cmdline is the command to run the interpreter, such as {"python3"} or {"docker", "run", "--rm",
+// "python:3.9", "python3"}; the Python code to run is appended to it as `-c CODE`.  It must be the
+// interpreter that will be used at run-time, so when cross-building (where there isn't a way to
+// run the target interpreter) the InstallScheme must be filled out by hand instead.
+func SchemeFromInterpreter(ctx context.Context, cmdline ...string) (InstallScheme, error) {
+	if len(cmdline) == 0 {
+		return InstallScheme{}, fmt.Errorf("SchemeFromInterpreter: empty command line")
+	}
+	cmd := exec.CommandContext(ctx, cmdline[0], append(cmdline[1:], "-c", schemeScript)...)
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return InstallScheme{}, fmt.Errorf("%s: %w: %s", cmdline[0], err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return InstallScheme{}, fmt.Errorf("%s: %w", cmdline[0], err)
+	}
+	var paths struct {
+		Python  string `json:"python"`
+		PureLib string `json:"purelib"`
+		PlatLib string `json:"platlib"`
+		Include string `json:"include"`
+		Scripts string `json:"scripts"`
+		Data    string `json:"data"`
+	}
+	if err := json.Unmarshal(out, &paths); err != nil {
+		return InstallScheme{}, fmt.Errorf("%s: parsing install scheme: %w", cmdline[0], err)
+	}
+	return InstallScheme{
+		Python:  paths.Python,
+		PureLib: paths.PureLib,
+		PlatLib: paths.PlatLib,
+		// pip installs headers to a subdirectory of "include" named after the distribution,
+		// and so do we.
+		Headers: paths.Include,
+		Scripts: paths.Scripts,
+		Data:    paths.Data,
+	}, nil
+}
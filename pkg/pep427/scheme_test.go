@@ -0,0 +1,43 @@
+package pep427_test
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/layertool/pkg/pep427"
+)
+
+func TestSchemeFromInterpreter(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not found")
+	}
+	t.Parallel()
+	ctx := context.Background()
+
+	scheme, err := pep427.SchemeFromInterpreter(ctx, "python3")
+	require.NoError(t, err)
+
+	out, err := exec.Command("python3", "-c",
+		`import sys, sysconfig; print(sys.executable); print(sysconfig.get_path("purelib"))`).
+		Output()
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, lines[0], scheme.Python)
+	assert.Equal(t, lines[1], scheme.PureLib)
+	assert.NotEmpty(t, scheme.PlatLib)
+	assert.NotEmpty(t, scheme.Headers)
+	assert.NotEmpty(t, scheme.Scripts)
+	assert.NotEmpty(t, scheme.Data)
+
+	_, err = pep427.SchemeFromInterpreter(ctx)
+	assert.EqualError(t, err, "SchemeFromInterpreter: empty command line")
+
+	_, err = pep427.SchemeFromInterpreter(ctx, "python3", "-c", "import sys; sys.exit('nope')")
+	assert.EqualError(t, err, "python3: exit status 1: nope")
+}
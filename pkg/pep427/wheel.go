@@ -4,18 +4,28 @@
 package pep427
 
 import (
+	"archive/tar"
 	"archive/zip"
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/textproto"
 	"path"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	ociv1 "github.com/google/go-containerregistry/pkg/v1"
+	ociv1tarball "github.com/google/go-containerregistry/pkg/v1/tarball"
 
-	"github.com/datawire/layertool/pkg/pep425"
 	"github.com/datawire/layertool/pkg/python"
+	"github.com/datawire/layertool/pkg/tarlayer"
 )
 
 type wheel struct {
@@ -66,29 +76,52 @@ func vercmp(a, b version) int {
 	return 0
 }
 
-type Platform struct {
-	Target struct {
-		// For shebangs
-		Python string // /usr/lib/python3
+// An InstallScheme is the set of directories that a wheel gets installed in to.  The directories
+// correspond to the keys of Python's `sysconfig.get_paths()`.
+type InstallScheme struct {
+	// For shebangs
+	Python string // /usr/bin/python3
 
-		// Installation directories
-		PureLib string // /usr/lib/python3.9/site-packages
-		PlatLib string // /usr/lib64/python3.9/site-packages
-		Headers string // /usr/include/python3.9/$name/
-		Scripts string // /usr/bin
-		Data    string // /usr
-	}
+	// Installation directories
+	PureLib string // /usr/lib/python3.9/site-packages
+	PlatLib string // /usr/lib64/python3.9/site-packages
+	Headers string // /usr/include/python3.9 (the distribution name gets appended)
+	Scripts string // /usr/bin
+	Data    string // /usr
+}
+
+// DefaultInstaller is what this package calls itself in `.dist-info/INSTALLER` files; see
+// Platform.Installer.
+const DefaultInstaller = "layertool"
+
+type Platform struct {
+	Target InstallScheme
 
 	// For byte-compiling
-	Python sring // /usr/lib/python3
+	Python string // /usr/lib/python3
 
-	Mkdir func(string) error
+	// Markers that pip writes in to the installed `.dist-info` directory, and that affect how
+	// `pip list` and `pip uninstall` behave in the image.  These are written only if asked for;
+	// like pip, they replace any files of the same name that the wheel itself contains.
+	WriteInstaller bool   // whether to write `INSTALLER`
+	Installer      string // contents of `INSTALLER`; if empty, DefaultInstaller is used (pip writes "pip")
+	Requested      bool   // whether to write `REQUESTED`, for distributions that were asked for by name
+
+	// NormalizeScripts makes scripts (from the `.data/scripts/` directory) that were built on
+	// Windows work on Linux: text scripts get CRLF line endings converted to LF, and only
+	// scripts that start with a "#!" line (or that are executable in the wheel) are made
+	// executable.  Scripts that contain a NUL byte are taken to be binaries, and are left
+	// alone.  Without it, like pip, all scripts are made executable and are otherwise left
+	// alone (aside from pointing `#!python` at Target.Python).
+	NormalizeScripts bool
 }
 
-func InstallWheel(ctx context.Context, wheelfilename string) error {
+// InstallWheel installs the wheel file in to the directories named by plat.Target, and returns a
+// layer containing just the installed files.
+func InstallWheel(ctx context.Context, plat Platform, wheelfilename string, opts ...ociv1tarball.LayerOption) (ociv1.Layer, error) {
 	zipReader, err := zip.OpenReader(wheelfilename)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer zipReader.Close()
 
@@ -103,26 +136,38 @@ func InstallWheel(ctx context.Context, wheelfilename string) error {
 	//
 	// - Unpack.
 	//   1. Parse `distribution-1.0.dist-info/WHEEL`.
-	metadata, err := wh.parseDistInfoWheel()
+	infoDir, err := wh.distInfoDir()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	metadata, err := wh.parseDistInfoWheel(infoDir)
+	if err != nil {
+		return nil, err
 	}
 	//   2. Check that installer is compatible with Wheel-Version. Warn if minor version is
 	//      greater, abort if major version is greater.
 	wheelVersion, err := parseVersion(metadata.Get("Wheel-Version"))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if wheelVersion[0] > specVersion[0] {
-		return fmt.Errorf("wheel file's Wheel-Version (%s) is not compatible with this wheel parser", wheelVersion)
+		return nil, fmt.Errorf("wheel file's Wheel-Version (%s) is not compatible with this wheel parser", wheelVersion)
 	}
 	if vercmp(wheelVersion, specVersion) > 0 {
 		dlog.Warnf(ctx, "wheel file's Wheel-Version (%s) is newer than this wheel parser", wheelVersion)
 	}
-	if metadata.Get("Root-Is-Purelib") == "true" {
+	var rootDir string
+	// pip compares this case-insensitively, so we do too.
+	switch strings.ToLower(metadata.Get("Root-Is-Purelib")) {
+	case "true":
 		//   3. If Root-Is-Purelib == 'true', unpack archive into purelib (site-packages).
-	} else {
+		rootDir = plat.Target.PureLib
+	case "false":
 		//   4. Else unpack archive into platlib (site-packages).
+		rootDir = plat.Target.PlatLib
+	default:
+		return nil, fmt.Errorf("wheel file's Root-Is-Purelib is neither \"true\" nor \"false\": %q",
+			metadata.Get("Root-Is-Purelib"))
 	}
 	// - Spread.
 	//   1. Unpacked archive includes `distribution-1.0.dist-info/` and (if there is data)
@@ -138,9 +183,281 @@ func InstallWheel(ctx context.Context, wheelfilename string) error {
 	//   5. Remove empty `distribution-1.0.data` directory.
 	//   6. Compile any installed .py to .pyc. (Uninstallers should be smart enough to remove
 	//      .pyc even if it is not mentioned in RECORD.)
+	//
+	// Since we're writing a layer rather than a real filesystem, we don't actually unpack and
+	// then move things; we do the "Unpack" and "Spread" phases together, putting each file
+	// right where it belongs (so step 5 is a no-op).  Step 6 isn't implemented yet.
+	dataDir := strings.TrimSuffix(infoDir, ".dist-info") + ".data"
+	distName := strings.SplitN(infoDir, "-", 2)[0]
+	dataDirs := map[string]string{
+		"purelib": plat.Target.PureLib,
+		"platlib": plat.Target.PlatLib,
+		"headers": path.Join(plat.Target.Headers, distName),
+		"scripts": plat.Target.Scripts,
+		"data":    plat.Target.Data,
+	}
+
+	recordFile := path.Join(infoDir, "RECORD")
+	var recordHeader *zip.FileHeader
+	var recordBody []byte
+	installed := make(map[string]string) // archive RECORD path => installed RECORD path
+	changed := make(map[string]bool)     // installed RECORD path => whether its content changed
+
+	layer := newLayerBuilder()
+	for _, file := range wh.zip.File {
+		name := path.Clean(file.Name)
+		if path.IsAbs(file.Name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("wheel file contains a path outside of the wheel: %q", file.Name)
+		}
+		isDir := strings.HasSuffix(file.Name, "/")
+
+		baseDir, relName := rootDir, name
+		isScript := false
+		if parts := strings.SplitN(name, "/", 3); parts[0] == dataDir {
+			if len(parts) == 1 {
+				continue
+			}
+			dir, ok := dataDirs[parts[1]]
+			if !ok {
+				return nil, fmt.Errorf("wheel file contains an unknown %s/ subdirectory: %q", dataDir, parts[1])
+			}
+			if len(parts) == 2 {
+				if !isDir {
+					return nil, fmt.Errorf("wheel file contains a file directly in %s/: %q", dataDir, file.Name)
+				}
+				continue
+			}
+			baseDir, relName = dir, parts[2]
+			isScript = parts[1] == "scripts"
+		}
+
+		for dir := path.Dir(relName); dir != "."; dir = path.Dir(dir) {
+			if err := layer.AddDir(path.Join(baseDir, dir)); err != nil {
+				return nil, err
+			}
+		}
+		if isDir {
+			if err := layer.AddDir(path.Join(baseDir, relName)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		body, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+		dst := path.Join(baseDir, relName)
+		installed[name] = recordPath(rootDir, dst)
+		if name == recordFile {
+			// Written below, once everything else is installed.
+			recordHeader, recordBody = &file.FileHeader, body
+			continue
+		}
+		mode := int64(0644)
+		if isExecutable(file) {
+			mode = 0755
+		}
+		if isScript {
+			fixed := body
+			if plat.NormalizeScripts && !bytes.Contains(fixed, []byte{0}) {
+				fixed = bytes.ReplaceAll(fixed, []byte("\r\n"), []byte("\n"))
+				if bytes.HasPrefix(fixed, []byte("#!")) {
+					mode = 0755
+				}
+			} else {
+				mode = 0755
+			}
+			fixed = fixScript(fixed, plat.Target.Python)
+			changed[installed[name]] = !bytes.Equal(fixed, body)
+			body = fixed
+		}
+		if err := layer.AddFile(dst, mode, file.Modified, body); err != nil {
+			return nil, err
+		}
+	}
+	if recordHeader == nil {
+		return nil, fmt.Errorf("file does not exist in wheel zip archive: %q", recordFile)
+	}
+
+	var generated []string
+	isGenerated := make(map[string]bool) // installed RECORD path => whether it was generated
+	addGenerated := func(filename string, body []byte) error {
+		dst := path.Join(rootDir, infoDir, filename)
+		generated = append(generated, dst)
+		isGenerated[recordPath(rootDir, dst)] = true
+		return layer.ReplaceFile(dst, 0644, recordHeader.Modified, body)
+	}
+	if plat.WriteInstaller {
+		installer := plat.Installer
+		if installer == "" {
+			installer = DefaultInstaller
+		}
+		if err := addGenerated("INSTALLER", []byte(installer+"\n")); err != nil {
+			return nil, err
+		}
+	}
+	if plat.Requested {
+		if err := addGenerated("REQUESTED", nil); err != nil {
+			return nil, err
+		}
+	}
+
+	// Updating RECORD (step 4) is based off of
+	// `pip/_internal/operations/install/wheel.py:get_csv_rows_for_installed()`.
+	oldRows, err := parseRecord(recordFile, recordBody)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]recordRow, 0, len(oldRows)+len(generated))
+	for _, row := range oldRows {
+		oldPath := path.Clean(row[0])
+		if newPath, ok := installed[oldPath]; ok {
+			row[0] = newPath
+			delete(installed, oldPath)
+		}
+		if isGenerated[row[0]] {
+			// The wheel's own copy got replaced; its row gets replaced below.
+			continue
+		}
+		if changed[row[0]] {
+			row[1], row[2] = recordHash(layer.bodies[layerPath(path.Join(rootDir, row[0]))])
+		}
+		rows = append(rows, row)
+	}
+	for _, filename := range generated {
+		hash, size := recordHash(layer.bodies[layerPath(filename)])
+		rows = append(rows, recordRow{recordPath(rootDir, filename), hash, size})
+	}
+	for _, newPath := range installed {
+		if isGenerated[newPath] {
+			continue
+		}
+		rows = append(rows, recordRow{newPath, "", ""})
+	}
+	record, err := writeRecord(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := layer.AddFile(path.Join(rootDir, recordFile), 0644, recordHeader.Modified, record); err != nil {
+		return nil, err
+	}
+
+	return layer.Layer(opts...)
+}
+
+// fixScript rewrites a `#!python` shebang to point at the given interpreter.
+//
+// This is based off of `pip/_internal/operations/install/wheel.py:fix_script()`, which also
+// discards any arguments on the `#!python` line.
+func fixScript(body []byte, python string) []byte {
+	if !bytes.HasPrefix(body, []byte("#!python")) {
+		return body
+	}
+	rest := []byte(nil)
+	if nl := bytes.IndexByte(body, '\n'); nl >= 0 {
+		rest = body[nl+1:]
+	}
+	return append([]byte("#!"+python+"\n"), rest...)
+}
+
+func readFile(file *zip.File) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("reading %q from wheel: %w", file.Name, err)
+	}
+	defer reader.Close()
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading %q from wheel: %w", file.Name, err)
+	}
+	return body, nil
+}
+
+// layerBuilder accumulates the files to be written to a layer, so that they can be written in a
+// deterministic order regardless of the order that they appear in the wheel.
+type layerBuilder struct {
+	entries map[string]*tar.Header
+	bodies  map[string][]byte
+}
+
+func newLayerBuilder() *layerBuilder {
+	return &layerBuilder{
+		entries: make(map[string]*tar.Header),
+		bodies:  make(map[string][]byte),
+	}
+}
+
+// layerPath turns an absolute filesystem path in to the name of a tar entry.
+func layerPath(filename string) string {
+	return strings.TrimPrefix(path.Clean("/"+filename), "/")
+}
+
+func (lb *layerBuilder) AddDir(dirname string) error {
+	name := layerPath(dirname)
+	if existing, ok := lb.entries[name]; ok {
+		if existing.Typeflag != tar.TypeDir {
+			return fmt.Errorf("wheel file contains both a file and a directory at %q", "/"+name)
+		}
+		return nil
+	}
+	lb.entries[name] = &tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0755,
+		ModTime:  time.Unix(0, 0),
+	}
+	return nil
+}
+
+func (lb *layerBuilder) AddFile(filename string, mode int64, mtime time.Time, body []byte) error {
+	name := layerPath(filename)
+	if _, ok := lb.entries[name]; ok {
+		return fmt.Errorf("wheel file contains multiple files that install to %q", "/"+name)
+	}
+	lb.entries[name] = &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     mode,
+		Size:     int64(len(body)),
+		ModTime:  mtime,
+	}
+	lb.bodies[name] = body
 	return nil
 }
 
+// ReplaceFile is like AddFile, but if there is already a file at that path, then it is replaced
+// instead of being a conflict.
+func (lb *layerBuilder) ReplaceFile(filename string, mode int64, mtime time.Time, body []byte) error {
+	name := layerPath(filename)
+	if existing, ok := lb.entries[name]; ok && existing.Typeflag == tar.TypeReg {
+		delete(lb.entries, name)
+		delete(lb.bodies, name)
+	}
+	return lb.AddFile(filename, mode, mtime, body)
+}
+
+func (lb *layerBuilder) Layer(opts ...ociv1tarball.LayerOption) (ociv1.Layer, error) {
+	names := make([]string, 0, len(lb.entries))
+	for name := range lb.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return tarlayer.Build(func(w io.Writer) error {
+		tarWriter := tar.NewWriter(w)
+		for _, name := range names {
+			if err := tarWriter.WriteHeader(lb.entries[name]); err != nil {
+				return err
+			}
+			if _, err := tarWriter.Write(lb.bodies[name]); err != nil {
+				return err
+			}
+		}
+		return tarWriter.Close()
+	}, opts...)
+}
+
 // This is based off of pip/_internal/utils/unpacking.py:zip_item_is_executable()`
 func isExecutable(f *zip.File) bool {
 	externalAttrs := python.ParseZIPExternalAttributes(f.FileHeader.ExternalAttrs)
@@ -189,17 +506,19 @@ func (wh *wheel) Open(filename string) (io.ReadCloser, error) {
 	return nil, fmt.Errorf("file does not exist in wheel zip archive: %q", filename)
 }
 
-func (wh *wheel) parseDistInfoWheel() (textproto.MIMEHeader, error) {
-	infoDir, err := wh.distInfoDir()
-	if err != nil {
-		return nil, err
-	}
-	wheelFile, err := wh.Open(path.Join(infoDir, "WHEEL"))
+func (wh *wheel) parseDistInfoWheel(infoDir string) (textproto.MIMEHeader, error) {
+	filename := path.Join(infoDir, "WHEEL")
+	wheelFile, err := wh.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer wheelFile.Close()
 
 	kvReader := textproto.NewReader(bufio.NewReader(wheelFile))
-	return kvReader.ReadMIMEHeader()
+	metadata, err := kvReader.ReadMIMEHeader()
+	// A WHEEL file that doesn't end with a blank line is fine.
+	if err != nil && !(errors.Is(err, io.EOF) && len(metadata) > 0) {
+		return nil, fmt.Errorf("parsing %q: %w", filename, err)
+	}
+	return metadata, nil
 }
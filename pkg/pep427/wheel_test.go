@@ -0,0 +1,544 @@
+package pep427_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	ociv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/layertool/pkg/pep427"
+)
+
+type testFile struct {
+	Mode int64
+	Body string
+}
+
+var testPlatform = pep427.Platform{
+	Target: pep427.InstallScheme{
+		Python:  "/usr/bin/python3",
+		PureLib: "/usr/lib/python3.9/site-packages",
+		PlatLib: "/usr/lib64/python3.9/site-packages",
+		Headers: "/usr/include/python3.9",
+		Scripts: "/usr/bin",
+		Data:    "/usr",
+	},
+	Python: "/usr/bin/python3",
+}
+
+// writeWheel writes a wheel zip file to a temporary directory, and returns the filename.
+func writeWheel(t *testing.T, files map[string]testFile) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "pep427-test.")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	filename := filepath.Join(dir, "foo-1.0-py3-none-any.whl")
+	fh, err := os.Create(filename)
+	require.NoError(t, err)
+	defer fh.Close()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	zipWriter := zip.NewWriter(fh)
+	for _, name := range names {
+		header := &zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+		header.SetMode(os.FileMode(files[name].Mode))
+		writer, err := zipWriter.CreateHeader(header)
+		require.NoError(t, err)
+		_, err = io.WriteString(writer, files[name].Body)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zipWriter.Close())
+	return filename
+}
+
+// readLayer returns the regular files in a layer, keyed by name.
+func readLayer(t *testing.T, layer ociv1.Layer) map[string]testFile {
+	t.Helper()
+	reader, err := layer.Uncompressed()
+	require.NoError(t, err)
+	defer reader.Close()
+
+	ret := make(map[string]testFile)
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		body, err := ioutil.ReadAll(tarReader)
+		require.NoError(t, err)
+		ret[header.Name] = testFile{Mode: header.Mode, Body: string(body)}
+	}
+	return ret
+}
+
+func wheelMetadata(rootIsPurelib string) testFile {
+	return testFile{
+		Mode: 0644,
+		Body: "Wheel-Version: 1.0\n" +
+			"Generator: bdist_wheel (0.36.2)\n" +
+			"Root-Is-Purelib: " + rootIsPurelib + "\n" +
+			"Tag: py3-none-any\n" +
+			"\n",
+	}
+}
+
+func TestInstallWheel(t *testing.T) {
+	t.Parallel()
+	testcases := map[string]struct {
+		Input          map[string]testFile
+		Markers        pep427.Platform // only the marker fields (WriteInstaller and such) are used
+		ExpectedOutput map[string]testFile
+		ExpectedErr    string
+	}{
+		"purelib": {
+			Input: map[string]testFile{
+				"foo/__init__.py":         {0644, "import sys\n"},
+				"foo-1.0.dist-info/WHEEL": wheelMetadata("true"),
+				"foo-1.0.dist-info/RECORD": {0644, "" +
+					"foo/__init__.py,sha256=xRdXeFHEieRauuJZElbEBASgXG0ZzU1a5_0isAhM7Gw,11\n" +
+					"foo-1.0.dist-info/WHEEL,sha256=OqRkF0eY5GHssMorFjlbTIq072vpHpF60fIQA6lS9xA,92\n" +
+					"foo-1.0.dist-info/RECORD,,\n"},
+			},
+			ExpectedOutput: map[string]testFile{
+				"usr/lib/python3.9/site-packages/foo/__init__.py":         {0644, "import sys\n"},
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/WHEEL": wheelMetadata("true"),
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/RECORD": {0644, "" +
+					"foo-1.0.dist-info/RECORD,,\r\n" +
+					"foo-1.0.dist-info/WHEEL,sha256=OqRkF0eY5GHssMorFjlbTIq072vpHpF60fIQA6lS9xA,92\r\n" +
+					"foo/__init__.py,sha256=xRdXeFHEieRauuJZElbEBASgXG0ZzU1a5_0isAhM7Gw,11\r\n"},
+			},
+		},
+		"platlib": {
+			Input: map[string]testFile{
+				"foo/_speedups.so":        {0755, "\x7fELF"},
+				"foo-1.0.dist-info/WHEEL": wheelMetadata("false"),
+				"foo-1.0.dist-info/RECORD": {0644, "" +
+					"foo/_speedups.so,sha256=O9u0_oOXzSuEJDCznM_wGoZjx1GUXvXpoJ4mf7ix01k,4\n" +
+					"foo-1.0.dist-info/WHEEL,sha256=4nchxM5aEXlnzu6QMdB54gBrKXtJOpzMnFskIDsgNhY,93\n" +
+					"foo-1.0.dist-info/RECORD,,\n"},
+			},
+			ExpectedOutput: map[string]testFile{
+				"usr/lib64/python3.9/site-packages/foo/_speedups.so":        {0755, "\x7fELF"},
+				"usr/lib64/python3.9/site-packages/foo-1.0.dist-info/WHEEL": wheelMetadata("false"),
+				"usr/lib64/python3.9/site-packages/foo-1.0.dist-info/RECORD": {0644, "" +
+					"foo-1.0.dist-info/RECORD,,\r\n" +
+					"foo-1.0.dist-info/WHEEL,sha256=4nchxM5aEXlnzu6QMdB54gBrKXtJOpzMnFskIDsgNhY,93\r\n" +
+					"foo/_speedups.so,sha256=O9u0_oOXzSuEJDCznM_wGoZjx1GUXvXpoJ4mf7ix01k,4\r\n"},
+			},
+		},
+		"data": {
+			Input: map[string]testFile{
+				"foo-1.0.data/platlib/_foo.so":     {0644, "\x7fELF"},
+				"foo-1.0.data/headers/foo.h":       {0644, "#pragma once\n"},
+				"foo-1.0.data/scripts/foo":         {0644, "#!python -E\nimport foo\n"},
+				"foo-1.0.data/scripts/foo.sh":      {0644, "#!/bin/sh\n"},
+				"foo-1.0.data/data/share/foo.txt":  {0644, "data\n"},
+				"foo-1.0.dist-info/WHEEL":          wheelMetadata("true"),
+				"foo-1.0.data/purelib/foo/util.py": {0644, ""},
+				// foo.sh is missing from RECORD
+				"foo-1.0.dist-info/RECORD": {0644, "" +
+					"foo-1.0.data/platlib/_foo.so,sha256=O9u0_oOXzSuEJDCznM_wGoZjx1GUXvXpoJ4mf7ix01k,4\n" +
+					"foo-1.0.data/headers/foo.h,sha256=s63xBtlbiTT2kJQ_KSEwi5C-W3ZsTIuiUMeS3lZAAgE,13\n" +
+					"foo-1.0.data/scripts/foo,sha256=aWIvDcxI0x4HhJOtT8Y5VFv1QWryhoUYAADOyGreeXk,23\n" +
+					"foo-1.0.data/data/share/foo.txt,sha256=Zmey0aq2oAyqWu5a-K2fFGXlZ6vxwgnRVyfVez6Pbl8,5\n" +
+					"foo-1.0.data/purelib/foo/util.py,sha256=47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU,0\n" +
+					"foo-1.0.dist-info/WHEEL,sha256=OqRkF0eY5GHssMorFjlbTIq072vpHpF60fIQA6lS9xA,92\n" +
+					"foo-1.0.dist-info/RECORD,,\n"},
+			},
+			Markers: pep427.Platform{WriteInstaller: true, Installer: "pip", Requested: true},
+			ExpectedOutput: map[string]testFile{
+				"usr/lib64/python3.9/site-packages/_foo.so":                   {0644, "\x7fELF"},
+				"usr/include/python3.9/foo/foo.h":                             {0644, "#pragma once\n"},
+				"usr/bin/foo":                                                 {0755, "#!/usr/bin/python3\nimport foo\n"},
+				"usr/bin/foo.sh":                                              {0755, "#!/bin/sh\n"},
+				"usr/share/foo.txt":                                           {0644, "data\n"},
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/WHEEL":     wheelMetadata("true"),
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/INSTALLER": {0644, "pip\n"},
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/REQUESTED": {0644, ""},
+				"usr/lib/python3.9/site-packages/foo/util.py":                 {0644, ""},
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/RECORD": {0644, "" +
+					"../../../bin/foo,sha256=D5VLY69kAP9M6PFvWXf8cGmiOKi9TQw39HBe6stcLtg,30\r\n" +
+					"../../../bin/foo.sh,,\r\n" +
+					"../../../include/python3.9/foo/foo.h,sha256=s63xBtlbiTT2kJQ_KSEwi5C-W3ZsTIuiUMeS3lZAAgE,13\r\n" +
+					"../../../lib64/python3.9/site-packages/_foo.so,sha256=O9u0_oOXzSuEJDCznM_wGoZjx1GUXvXpoJ4mf7ix01k,4\r\n" +
+					"../../../share/foo.txt,sha256=Zmey0aq2oAyqWu5a-K2fFGXlZ6vxwgnRVyfVez6Pbl8,5\r\n" +
+					"foo-1.0.dist-info/INSTALLER,sha256=zuuue4knoyJ-UwPPXg8fezS7VCrXJQrAP7zeNuwvFQg,4\r\n" +
+					"foo-1.0.dist-info/RECORD,,\r\n" +
+					"foo-1.0.dist-info/REQUESTED,sha256=47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU,0\r\n" +
+					"foo-1.0.dist-info/WHEEL,sha256=OqRkF0eY5GHssMorFjlbTIq072vpHpF60fIQA6lS9xA,92\r\n" +
+					"foo/util.py,sha256=47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU,0\r\n"},
+			},
+		},
+		"existing-markers": {
+			Input: map[string]testFile{
+				"foo/__init__.py":             {0644, ""},
+				"foo-1.0.dist-info/WHEEL":     wheelMetadata("true"),
+				"foo-1.0.dist-info/INSTALLER": {0644, "other\n"},
+				"foo-1.0.dist-info/REQUESTED": {0644, "yes\n"},
+				"foo-1.0.dist-info/RECORD": {0644, "" +
+					"foo/__init__.py,sha256=47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU,0\n" +
+					"foo-1.0.dist-info/WHEEL,sha256=OqRkF0eY5GHssMorFjlbTIq072vpHpF60fIQA6lS9xA,92\n" +
+					"foo-1.0.dist-info/INSTALLER,sha256=fk-i64x6wIlznV3vxEifrWihANkggso1xrQKRSSCH4c,6\n" +
+					"foo-1.0.dist-info/REQUESTED,sha256=UEBiWx-2-krwciZoP25gA7KeXnCxb4z7JL56dSOT8O4,4\n" +
+					"foo-1.0.dist-info/RECORD,,\n"},
+			},
+			Markers: pep427.Platform{WriteInstaller: true, Installer: "pip", Requested: true},
+			ExpectedOutput: map[string]testFile{
+				"usr/lib/python3.9/site-packages/foo/__init__.py":             {0644, ""},
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/WHEEL":     wheelMetadata("true"),
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/INSTALLER": {0644, "pip\n"},
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/REQUESTED": {0644, ""},
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/RECORD": {0644, "" +
+					"foo-1.0.dist-info/INSTALLER,sha256=zuuue4knoyJ-UwPPXg8fezS7VCrXJQrAP7zeNuwvFQg,4\r\n" +
+					"foo-1.0.dist-info/RECORD,,\r\n" +
+					"foo-1.0.dist-info/REQUESTED,sha256=47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU,0\r\n" +
+					"foo-1.0.dist-info/WHEEL,sha256=OqRkF0eY5GHssMorFjlbTIq072vpHpF60fIQA6lS9xA,92\r\n" +
+					"foo/__init__.py,sha256=47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU,0\r\n"},
+			},
+		},
+		"existing-markers-kept": {
+			Input: map[string]testFile{
+				"foo-1.0.dist-info/WHEEL":     wheelMetadata("true"),
+				"foo-1.0.dist-info/INSTALLER": {0644, "other\n"},
+				"foo-1.0.dist-info/RECORD": {0644, "" +
+					"foo-1.0.dist-info/WHEEL,sha256=OqRkF0eY5GHssMorFjlbTIq072vpHpF60fIQA6lS9xA,92\n" +
+					"foo-1.0.dist-info/INSTALLER,sha256=fk-i64x6wIlznV3vxEifrWihANkggso1xrQKRSSCH4c,6\n" +
+					"foo-1.0.dist-info/RECORD,,\n"},
+			},
+			ExpectedOutput: map[string]testFile{
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/WHEEL":     wheelMetadata("true"),
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/INSTALLER": {0644, "other\n"},
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/RECORD": {0644, "" +
+					"foo-1.0.dist-info/INSTALLER,sha256=fk-i64x6wIlznV3vxEifrWihANkggso1xrQKRSSCH4c,6\r\n" +
+					"foo-1.0.dist-info/RECORD,,\r\n" +
+					"foo-1.0.dist-info/WHEEL,sha256=OqRkF0eY5GHssMorFjlbTIq072vpHpF60fIQA6lS9xA,92\r\n"},
+			},
+		},
+		"existing-installer-not-in-record": {
+			Input: map[string]testFile{
+				"foo-1.0.dist-info/WHEEL":     wheelMetadata("true"),
+				"foo-1.0.dist-info/INSTALLER": {0644, "other\n"},
+				"foo-1.0.dist-info/RECORD": {0644, "" +
+					"foo-1.0.dist-info/WHEEL,sha256=OqRkF0eY5GHssMorFjlbTIq072vpHpF60fIQA6lS9xA,92\n" +
+					"foo-1.0.dist-info/RECORD,,\n"},
+			},
+			Markers: pep427.Platform{WriteInstaller: true},
+			ExpectedOutput: map[string]testFile{
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/WHEEL":     wheelMetadata("true"),
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/INSTALLER": {0644, "layertool\n"},
+				"usr/lib/python3.9/site-packages/foo-1.0.dist-info/RECORD": {0644, "" +
+					"foo-1.0.dist-info/INSTALLER,sha256=IlSe9oTmAZztSLOz0KCDrizGM0JG6JrpqWNOzitPJV0,10\r\n" +
+					"foo-1.0.dist-info/RECORD,,\r\n" +
+					"foo-1.0.dist-info/WHEEL,sha256=OqRkF0eY5GHssMorFjlbTIq072vpHpF60fIQA6lS9xA,92\r\n"},
+			},
+		},
+		"missing-record": {
+			Input: map[string]testFile{
+				"foo/__init__.py":         {0644, ""},
+				"foo-1.0.dist-info/WHEEL": wheelMetadata("true"),
+			},
+			ExpectedErr: `file does not exist in wheel zip archive: "foo-1.0.dist-info/RECORD"`,
+		},
+		"missing-wheel": {
+			Input: map[string]testFile{
+				"foo/__init__.py":          {0644, ""},
+				"foo-1.0.dist-info/RECORD": {0644, ""},
+			},
+			ExpectedErr: `file does not exist in wheel zip archive: "foo-1.0.dist-info/WHEEL"`,
+		},
+		"missing-dist-info": {
+			Input: map[string]testFile{
+				"foo/__init__.py": {0644, ""},
+			},
+			ExpectedErr: `.dist-info directory not found`,
+		},
+		"malformed-wheel": {
+			Input: map[string]testFile{
+				"foo-1.0.dist-info/WHEEL": {0644, "Wheel-Version: one\nRoot-Is-Purelib: true\n\n"},
+			},
+			ExpectedErr: `could not parse wheel version number: "one": strconv.Atoi: parsing "one": invalid syntax`,
+		},
+		"missing-root-is-purelib": {
+			Input: map[string]testFile{
+				"foo-1.0.dist-info/WHEEL": {0644, "Wheel-Version: 1.0\n\n"},
+			},
+			ExpectedErr: `wheel file's Root-Is-Purelib is neither "true" nor "false": ""`,
+		},
+		"bad-root-is-purelib": {
+			Input: map[string]testFile{
+				"foo-1.0.dist-info/WHEEL": wheelMetadata("yes"),
+			},
+			ExpectedErr: `wheel file's Root-Is-Purelib is neither "true" nor "false": "yes"`,
+		},
+		"future-wheel-version": {
+			Input: map[string]testFile{
+				"foo-1.0.dist-info/WHEEL": {0644, "Wheel-Version: 2.0\nRoot-Is-Purelib: true\n\n"},
+			},
+			ExpectedErr: `wheel file's Wheel-Version (2.0) is not compatible with this wheel parser`,
+		},
+		"unknown-data-key": {
+			Input: map[string]testFile{
+				"foo-1.0.data/bogus/foo":  {0644, ""},
+				"foo-1.0.dist-info/WHEEL": wheelMetadata("true"),
+			},
+			ExpectedErr: `wheel file contains an unknown foo-1.0.data/ subdirectory: "bogus"`,
+		},
+		"path-traversal": {
+			Input: map[string]testFile{
+				"../etc/passwd":           {0644, ""},
+				"foo-1.0.dist-info/WHEEL": wheelMetadata("true"),
+			},
+			ExpectedErr: `wheel file contains a path outside of the wheel: "../etc/passwd"`,
+		},
+		"conflict": {
+			Input: map[string]testFile{
+				"foo.py":                      {0644, ""},
+				"foo-1.0.data/purelib/foo.py": {0644, ""},
+				"foo-1.0.dist-info/WHEEL":     wheelMetadata("true"),
+			},
+			ExpectedErr: `wheel file contains multiple files that install to "/usr/lib/python3.9/site-packages/foo.py"`,
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			filename := writeWheel(t, tc.Input)
+			plat := testPlatform
+			plat.WriteInstaller = tc.Markers.WriteInstaller
+			plat.Installer = tc.Markers.Installer
+			plat.Requested = tc.Markers.Requested
+			layer, err := pep427.InstallWheel(context.Background(), plat, filename)
+			if tc.ExpectedErr != "" {
+				assert.EqualError(t, err, tc.ExpectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.ExpectedOutput, readLayer(t, layer))
+		})
+	}
+}
+
+// installFiles installs a wheel containing the given files (plus minimal metadata), and returns
+// the installed files, excluding the `.dist-info` directory.
+func installFiles(t *testing.T, rootIsPurelib string, files map[string]testFile) (map[string]testFile, error) {
+	t.Helper()
+	input := map[string]testFile{
+		"foo-1.0.dist-info/WHEEL":  wheelMetadata(rootIsPurelib),
+		"foo-1.0.dist-info/RECORD": {0644, ""},
+	}
+	for name, file := range files {
+		input[name] = file
+	}
+	layer, err := pep427.InstallWheel(context.Background(), testPlatform, writeWheel(t, input))
+	if err != nil {
+		return nil, err
+	}
+	output := readLayer(t, layer)
+	for name := range output {
+		if strings.Contains(name, "/foo-1.0.dist-info/") {
+			delete(output, name)
+		}
+	}
+	return output, nil
+}
+
+func TestInstallWheelSpread(t *testing.T) {
+	t.Parallel()
+	testcases := map[string]string{
+		"purelib": "usr/lib/python3.9/site-packages/sub/x",
+		"platlib": "usr/lib64/python3.9/site-packages/sub/x",
+		"headers": "usr/include/python3.9/foo/sub/x",
+		"scripts": "usr/bin/sub/x",
+		"data":    "usr/sub/x",
+	}
+	for key, expected := range testcases {
+		key, expected := key, expected
+		t.Run(key, func(t *testing.T) {
+			t.Parallel()
+			mode := int64(0644)
+			if key == "scripts" {
+				mode = 0755
+			}
+			// Where the file goes doesn't depend on Root-Is-Purelib.
+			for _, rootIsPurelib := range []string{"true", "false"} {
+				output, err := installFiles(t, rootIsPurelib, map[string]testFile{
+					"foo-1.0.data/" + key + "/sub/x": {0644, "x"},
+				})
+				require.NoError(t, err)
+				assert.Equal(t, map[string]testFile{expected: {mode, "x"}}, output)
+			}
+		})
+	}
+}
+
+func TestInstallWheelScripts(t *testing.T) {
+	t.Parallel()
+	testcases := map[string]struct {
+		Input          testFile
+		ExpectedOutput testFile
+	}{
+		"python":            {Input: testFile{0644, "#!python\nimport foo\n"}, ExpectedOutput: testFile{0755, "#!/usr/bin/python3\nimport foo\n"}},
+		"python-args":       {Input: testFile{0644, "#!python -E -s\nimport foo\n"}, ExpectedOutput: testFile{0755, "#!/usr/bin/python3\nimport foo\n"}},
+		"pythonw":           {Input: testFile{0644, "#!pythonw\nimport foo\n"}, ExpectedOutput: testFile{0755, "#!/usr/bin/python3\nimport foo\n"}},
+		"python-no-newline": {Input: testFile{0644, "#!python"}, ExpectedOutput: testFile{0755, "#!/usr/bin/python3\n"}},
+		"other-shebang":     {Input: testFile{0644, "#!/bin/sh\necho foo\n"}, ExpectedOutput: testFile{0755, "#!/bin/sh\necho foo\n"}},
+		"env-python":        {Input: testFile{0644, "#!/usr/bin/env python\n"}, ExpectedOutput: testFile{0755, "#!/usr/bin/env python\n"}},
+		"no-shebang":        {Input: testFile{0644, "import foo\n"}, ExpectedOutput: testFile{0755, "import foo\n"}},
+		"indented-shebang":  {Input: testFile{0644, " #!python\n"}, ExpectedOutput: testFile{0755, " #!python\n"}},
+		"already-exec":      {Input: testFile{0755, "#!python\n"}, ExpectedOutput: testFile{0755, "#!/usr/bin/python3\n"}},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			output, err := installFiles(t, "true", map[string]testFile{
+				"foo-1.0.data/scripts/foo": tc.Input,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]testFile{"usr/bin/foo": tc.ExpectedOutput}, output)
+		})
+	}
+
+	// Only scripts get rewritten or made executable.
+	output, err := installFiles(t, "true", map[string]testFile{
+		"foo/__main__.py":               {0644, "#!python\n"},
+		"foo-1.0.data/data/share/foo":   {0644, "#!python\n"},
+		"foo-1.0.data/purelib/foo/x.py": {0755, "#!python\n"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]testFile{
+		"usr/lib/python3.9/site-packages/foo/__main__.py": {0644, "#!python\n"},
+		"usr/share/foo": {0644, "#!python\n"},
+		"usr/lib/python3.9/site-packages/foo/x.py": {0755, "#!python\n"},
+	}, output)
+}
+
+func TestInstallWheelConflicts(t *testing.T) {
+	t.Parallel()
+	testcases := map[string]struct {
+		RootIsPurelib string
+		Input         []string
+		ExpectedErr   string
+	}{
+		"root-vs-purelib": {
+			RootIsPurelib: "true",
+			Input:         []string{"foo.py", "foo-1.0.data/purelib/foo.py"},
+			ExpectedErr:   `wheel file contains multiple files that install to "/usr/lib/python3.9/site-packages/foo.py"`,
+		},
+		"root-vs-platlib": {
+			RootIsPurelib: "false",
+			Input:         []string{"foo.py", "foo-1.0.data/platlib/foo.py"},
+			ExpectedErr:   `wheel file contains multiple files that install to "/usr/lib64/python3.9/site-packages/foo.py"`,
+		},
+		"scripts-vs-data": {
+			RootIsPurelib: "true",
+			Input:         []string{"foo-1.0.data/scripts/foo", "foo-1.0.data/data/bin/foo"},
+			ExpectedErr:   `wheel file contains multiple files that install to "/usr/bin/foo"`,
+		},
+		"file-vs-dir": {
+			RootIsPurelib: "true",
+			Input:         []string{"foo", "foo-1.0.data/purelib/foo/bar.py"},
+			ExpectedErr:   `wheel file contains both a file and a directory at "/usr/lib/python3.9/site-packages/foo"`,
+		},
+		"no-conflict": {
+			RootIsPurelib: "true",
+			Input:         []string{"foo.py", "foo-1.0.data/platlib/foo.py"},
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			files := make(map[string]testFile, len(tc.Input))
+			for _, name := range tc.Input {
+				files[name] = testFile{0644, ""}
+			}
+			_, err := installFiles(t, tc.RootIsPurelib, files)
+			if tc.ExpectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.ExpectedErr)
+			}
+		})
+	}
+}
+
+func TestInstallWheelNormalizeScripts(t *testing.T) {
+	t.Parallel()
+	// As built on Windows.
+	filename := writeWheel(t, map[string]testFile{
+		"foo-1.0.data/scripts/foo.sh":  {0644, "#!/bin/sh\r\necho hi\r\n"},
+		"foo-1.0.data/scripts/foo.py":  {0644, "#!python\r\nimport foo\r\n"},
+		"foo-1.0.data/scripts/foo.bat": {0644, "rem batch\r\n"},
+		"foo-1.0.data/scripts/foo.bin": {0644, "\x7fELF\x00\r\n"},
+		"foo-1.0.data/scripts/run":     {0755, "echo hi\r\n"}, // not in RECORD
+		"foo-1.0.data/data/foo.txt":    {0644, "text\r\n"},
+		"foo-1.0.dist-info/WHEEL":      wheelMetadata("true"),
+		"foo-1.0.dist-info/RECORD": {0644, "" +
+			"foo-1.0.data/scripts/foo.sh,sha256=JJ2rZ4PVb1AeuHfcF1T0jQz76DoyjLvDW3knGQ3bDKs,20\r\n" +
+			"foo-1.0.data/scripts/foo.py,sha256=SH3HR_8L7JShCnaqbnQcHayt21jlfsWhcRvYVVequt8,22\r\n" +
+			"foo-1.0.data/scripts/foo.bat,sha256=ZkN57VODkhyUOFEfGRkiEQT0nSj6iMror9LGnjKWy4Q,11\r\n" +
+			"foo-1.0.data/scripts/foo.bin,sha256=PMNuTjd8FYV4pd72B_CCx9h7P1vPYq7IzG9JSJpWS7w,7\r\n" +
+			"foo-1.0.dist-info/WHEEL,sha256=OqRkF0eY5GHssMorFjlbTIq072vpHpF60fIQA6lS9xA,92\r\n" +
+			"foo-1.0.dist-info/RECORD,,\r\n"},
+	})
+
+	// By default, only `#!python` gets rewritten, and every script is made executable.
+	layer, err := pep427.InstallWheel(context.Background(), testPlatform, filename)
+	require.NoError(t, err)
+	output := readLayer(t, layer)
+	assert.Equal(t, testFile{0755, "#!/bin/sh\r\necho hi\r\n"}, output["usr/bin/foo.sh"])
+	assert.Equal(t, testFile{0755, "#!/usr/bin/python3\nimport foo\r\n"}, output["usr/bin/foo.py"])
+	assert.Equal(t, testFile{0755, "rem batch\r\n"}, output["usr/bin/foo.bat"])
+
+	plat := testPlatform
+	plat.NormalizeScripts = true
+	layer, err = pep427.InstallWheel(context.Background(), plat, filename)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]testFile{
+		"usr/bin/foo.sh":  {0755, "#!/bin/sh\necho hi\n"},
+		"usr/bin/foo.py":  {0755, "#!/usr/bin/python3\nimport foo\n"},
+		"usr/bin/foo.bat": {0644, "rem batch\n"},
+		"usr/bin/foo.bin": {0755, "\x7fELF\x00\r\n"},
+		"usr/bin/run":     {0755, "echo hi\n"},
+		// Only scripts are normalized.
+		"usr/foo.txt": {0644, "text\r\n"},
+		"usr/lib/python3.9/site-packages/foo-1.0.dist-info/WHEEL": wheelMetadata("true"),
+		"usr/lib/python3.9/site-packages/foo-1.0.dist-info/RECORD": {0644, "" +
+			"../../../bin/foo.bat,sha256=peZK9Cp-lax05DvCjEHm1jnwCrTrIJRqF5TC7BJWzvY,10\r\n" +
+			"../../../bin/foo.bin,sha256=PMNuTjd8FYV4pd72B_CCx9h7P1vPYq7IzG9JSJpWS7w,7\r\n" +
+			"../../../bin/foo.py,sha256=D5VLY69kAP9M6PFvWXf8cGmiOKi9TQw39HBe6stcLtg,30\r\n" +
+			"../../../bin/foo.sh,sha256=KZABho-4wC_UMcM2xtBY9VWMXf9bWvXm_gS4cKapy7o,18\r\n" +
+			"../../../bin/run,,\r\n" +
+			"../../../foo.txt,,\r\n" +
+			"foo-1.0.dist-info/RECORD,,\r\n" +
+			"foo-1.0.dist-info/WHEEL,sha256=OqRkF0eY5GHssMorFjlbTIq072vpHpF60fIQA6lS9xA,92\r\n"},
+	}, readLayer(t, layer))
+}
@@ -0,0 +1,67 @@
+//go:build go1.18
+// +build go1.18
+
+package marshal_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/datawire/layertool/pkg/python/marshal"
+	"github.com/datawire/layertool/pkg/python/pyc"
+)
+
+// FuzzParsePyc checks that parsing arbitrary (untrusted) pyc files never panics, and that
+// anything that parses successfully can be re-encoded.  Marshal always picks the canonical
+// encoding while CPython doesn't always, so rather than comparing against the input, it checks
+// that re-encoding is a fixed point.
+func FuzzParsePyc(f *testing.F) {
+	filenames, err := filepath.Glob(filepath.Join("..", "pyc", "testdata", "*.pyc"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, filename := range filenames {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(content)
+	}
+	// Inputs that have caused Unmarshal to use far more time or memory than the input size
+	// warrants; see TestUnmarshalResources.
+	hdr := pyc.WriteHeader(pyc.Header{Magic: 3495}) // 3.11
+	f.Add(append(append([]byte(nil), hdr...), hugeLong(1<<20)...))
+	f.Add(append(append([]byte(nil), hdr...), nestedLists(1500, 256<<10)...))
+
+	f.Fuzz(func(t *testing.T, content []byte) {
+		hdr, data, err := pyc.ParseHeader(content)
+		if err != nil {
+			return
+		}
+		if string(pyc.WriteHeader(hdr)) != string(content[:pyc.HeaderSize]) {
+			t.Fatalf("header did not round-trip: %q", content[:pyc.HeaderSize])
+		}
+		major, minor, _ := hdr.Magic.Version()
+
+		obj, err := marshal.Unmarshal(data, major, minor)
+		if err != nil {
+			return
+		}
+		encoded, err := marshal.Marshal(obj, major, minor)
+		if err != nil {
+			t.Fatalf("could not re-encode %#v: %v", obj, err)
+		}
+		reobj, err := marshal.Unmarshal(encoded, major, minor)
+		if err != nil {
+			t.Fatalf("could not decode re-encoded %q: %v", encoded, err)
+		}
+		reencoded, err := marshal.Marshal(reobj, major, minor)
+		if err != nil {
+			t.Fatalf("could not re-encode %#v: %v", reobj, err)
+		}
+		if string(encoded) != string(reencoded) {
+			t.Fatalf("re-encoding is not stable:\n%q\n%q", encoded, reencoded)
+		}
+	})
+}
@@ -0,0 +1,899 @@
+// Package marshal implements the subset of Python's `marshal` serialization format that is used
+// to store code objects in .pyc files.
+//
+// The format is only ever documented in `Python/marshal.c`; this package mimics that file's
+// behavior for marshal format version 4 (which is what Python 3.4 and later write), as used by
+// Python 3.7 through 3.13.  The layout of code objects differs between Python versions, so the
+// Python version must be passed to Unmarshal and Marshal.
+//
+// Decoded objects preserve everything needed to re-encode them byte-for-byte the way CPython
+// would have; notably, which objects were flagged as being the target of back-references (see
+// Flagged and Ref).
+package marshal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+// Type codes, from `Python/marshal.c`.
+const (
+	typeNull               = '0'
+	typeNone               = 'N'
+	typeFalse              = 'F'
+	typeTrue               = 'T'
+	typeStopIter           = 'S'
+	typeEllipsis           = '.'
+	typeInt                = 'i'
+	typeInt64              = 'I' // only ever read, never written, by modern CPython
+	typeFloat              = 'f' // marshal versions 0 and 1
+	typeBinaryFloat        = 'g'
+	typeComplex            = 'x' // marshal versions 0 and 1
+	typeBinaryComplex      = 'y'
+	typeLong               = 'l'
+	typeString             = 's'
+	typeInterned           = 't'
+	typeRef                = 'r'
+	typeTuple              = '('
+	typeList               = '['
+	typeDict               = '{'
+	typeCode               = 'c'
+	typeUnicode            = 'u'
+	typeUnknown            = '?'
+	typeSet                = '<'
+	typeFrozenSet          = '>'
+	typeASCII              = 'a'
+	typeASCIIInterned      = 'A'
+	typeSmallTuple         = ')'
+	typeShortASCII         = 'z'
+	typeShortASCIIInterned = 'Z'
+
+	flagRef = 0x80
+)
+
+// maxDepth mimics `MAX_MARSHAL_STACK_DEPTH`.
+const maxDepth = 2000
+
+// maxPrealloc is the most items that will be allocated for a container before actually reading
+// them.
+const maxPrealloc = 1024
+
+// An Object is a value that can be marshalled.  It is one of the types in this package.
+type Object interface {
+	isObject()
+}
+
+type (
+	// None is Python's `None`.
+	None struct{}
+	// StopIteration is Python's `StopIteration` exception type.
+	StopIteration struct{}
+	// Ellipsis is Python's `...`.
+	Ellipsis struct{}
+	// Bool is Python's `True` or `False`.
+	Bool bool
+	// Int is an int that fits in 32 bits.
+	Int int32
+	// Int64 is an int that was marshalled as 64 bits; modern CPython never writes these.
+	Int64 int64
+	// Long is an int that doesn't fit in 32 bits.
+	Long struct{ *big.Int }
+	// Float is a float.
+	Float float64
+	// Complex is a complex.
+	Complex complex128
+	// Bytes is a bytes.
+	Bytes []byte
+	// String is a str.  The Value is UTF-8, except that (as with Python's "surrogatepass" error
+	// handler) it may contain encoded lone surrogates.
+	String struct {
+		Value    string
+		Interned bool
+	}
+	// Tuple is a tuple.
+	Tuple []Object
+	// List is a list.
+	List []Object
+	// Dict is a dict, in order.
+	Dict []DictItem
+	// Set is a set, in order.
+	Set []Object
+	// FrozenSet is a frozenset, in order.
+	FrozenSet []Object
+)
+
+// A DictItem is a key/value pair in a Dict.
+type DictItem struct {
+	Key   Object
+	Value Object
+}
+
+// A Code is a code object.  Which fields are used depends on the Python version; fields that
+// aren't used by a version are nil (or zero) after Unmarshal, and are ignored by Marshal.
+type Code struct {
+	ArgCount        int32
+	PosOnlyArgCount int32 // 3.8+
+	KwOnlyArgCount  int32
+	NLocals         int32 // <3.11
+	StackSize       int32
+	Flags           int32
+	Code            Object
+	Consts          Object
+	Names           Object
+	VarNames        Object // <3.11
+	FreeVars        Object // <3.11
+	CellVars        Object // <3.11
+	LocalsPlusNames Object // 3.11+
+	LocalsPlusKinds Object // 3.11+
+	Filename        Object
+	Name            Object
+	QualName        Object // 3.11+
+	FirstLineNo     int32
+	LineTable       Object // co_lnotab before 3.10, co_linetable after
+	ExceptionTable  Object // 3.11+
+}
+
+// A Flagged is an Object that was marshalled with FLAG_REF set, adding it to the table of objects
+// that later Refs may refer back to.  The singletons (None, StopIteration, Ellipsis, and Bool)
+// and Refs are never added to the table, and so are never Flagged.
+type Flagged struct {
+	Object Object
+}
+
+// A Ref is a back-reference to an earlier Flagged object.  The Index counts Flagged objects in
+// the order that their encodings begin; which is the order that References returns them in.
+type Ref struct {
+	Index int
+}
+
+func (None) isObject()          {}
+func (StopIteration) isObject() {}
+func (Ellipsis) isObject()      {}
+func (Bool) isObject()          {}
+func (Int) isObject()           {}
+func (Int64) isObject()         {}
+func (Long) isObject()          {}
+func (Float) isObject()         {}
+func (Complex) isObject()       {}
+func (Bytes) isObject()         {}
+func (String) isObject()        {}
+func (Tuple) isObject()         {}
+func (List) isObject()          {}
+func (Dict) isObject()          {}
+func (Set) isObject()           {}
+func (FrozenSet) isObject()     {}
+func (*Code) isObject()         {}
+func (Flagged) isObject()       {}
+func (Ref) isObject()           {}
+
+func checkVersion(major, minor int) error {
+	if major != 3 || minor < 7 || minor > 13 {
+		return fmt.Errorf("marshal: unsupported Python version: %d.%d", major, minor)
+	}
+	return nil
+}
+
+// References returns the table of Flagged objects in obj, such that a Ref's Index is an index in
+// to the returned list.
+func References(obj Object) []Object {
+	var refs []Object
+	var walk func(Object)
+	walkAll := func(objs ...Object) {
+		for _, o := range objs {
+			walk(o)
+		}
+	}
+	walk = func(obj Object) {
+		switch obj := obj.(type) {
+		case Flagged:
+			refs = append(refs, obj.Object)
+			walk(obj.Object)
+		case Tuple:
+			walkAll(obj...)
+		case List:
+			walkAll(obj...)
+		case Set:
+			walkAll(obj...)
+		case FrozenSet:
+			walkAll(obj...)
+		case Dict:
+			for _, item := range obj {
+				walkAll(item.Key, item.Value)
+			}
+		case *Code:
+			walkAll(obj.Code, obj.Consts, obj.Names,
+				obj.VarNames, obj.FreeVars, obj.CellVars,
+				obj.LocalsPlusNames, obj.LocalsPlusKinds,
+				obj.Filename, obj.Name, obj.QualName,
+				obj.LineTable, obj.ExceptionTable)
+		}
+	}
+	walk(obj)
+	return refs
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type decoder struct {
+	major, minor int
+
+	data []byte
+	pos  int
+
+	// refs tracks, for each entry in the reference table, whether the object is complete;
+	// code objects and frozensets may not be referred to until they have been fully read.
+	refs  []bool
+	depth int
+}
+
+// Unmarshal decodes a marshalled object (such as the code object following a pyc header), as
+// written by the given version of Python.  Unlike Python's `marshal.loads()`, it is an error for
+// there to be trailing data after the object.
+func Unmarshal(data []byte, major, minor int) (Object, error) {
+	if err := checkVersion(major, minor); err != nil {
+		return nil, err
+	}
+	d := &decoder{
+		major: major,
+		minor: minor,
+		data:  data,
+	}
+	obj, err := d.readObject()
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("marshal: %d bytes of trailing data", len(d.data)-d.pos)
+	}
+	return obj, nil
+}
+
+func (d *decoder) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("marshal: bad marshal data at offset %d: %s", d.pos, fmt.Sprintf(format, a...))
+}
+
+func (d *decoder) readBytes(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, d.errorf("EOF read where object expected")
+	}
+	ret := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return ret, nil
+}
+
+func (d *decoder) readByte() (byte, error) {
+	b, err := d.readBytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (d *decoder) readInt32() (int32, error) {
+	b, err := d.readBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(b)), nil
+}
+
+func (d *decoder) readFloat64() (float64, error) {
+	b, err := d.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+}
+
+// readSize reads a 32-bit length for a container of items that each take at least 1 byte, and
+// checks it for sanity before the caller allocates anything.
+func (d *decoder) readSize(what string) (int, error) {
+	n, err := d.readInt32()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, d.errorf("%s size out of range", what)
+	}
+	if int(n) > len(d.data)-d.pos {
+		return 0, d.errorf("EOF read where object expected")
+	}
+	return int(n), nil
+}
+
+func (d *decoder) readObjects(n int) ([]Object, error) {
+	// n has only been checked against the length of the input, so don't trust it too much;
+	// otherwise nested containers could each preallocate far more than the input could fill.
+	capacity := n
+	if capacity > maxPrealloc {
+		capacity = maxPrealloc
+	}
+	ret := make([]Object, 0, capacity)
+	for i := 0; i < n; i++ {
+		obj, err := d.readObject()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, obj)
+	}
+	return ret, nil
+}
+
+func (d *decoder) readObject() (Object, error) {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > maxDepth {
+		return nil, d.errorf("recursion limit exceeded")
+	}
+
+	code, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	flag := code&flagRef != 0
+	typ := code &^ flagRef
+
+	// Singletons and refs are never added to the reference table.
+	switch typ {
+	case typeNone:
+		return None{}, nil
+	case typeStopIter:
+		return StopIteration{}, nil
+	case typeEllipsis:
+		return Ellipsis{}, nil
+	case typeFalse:
+		return Bool(false), nil
+	case typeTrue:
+		return Bool(true), nil
+	case typeRef:
+		n, err := d.readInt32()
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 || int(n) >= len(d.refs) || !d.refs[n] {
+			return nil, d.errorf("invalid reference")
+		}
+		return Ref{Index: int(n)}, nil
+	}
+
+	// Everything else may be.  The slot in the table is reserved before reading the object's
+	// contents; for most types it may be referred to right away, but code objects and
+	// frozensets must be complete first.
+	refIdx := -1
+	if flag {
+		refIdx = len(d.refs)
+		d.refs = append(d.refs, typ != typeCode && typ != typeFrozenSet)
+	}
+	obj, err := d.readValue(typ)
+	if err != nil {
+		return nil, err
+	}
+	if refIdx < 0 {
+		return obj, nil
+	}
+	d.refs[refIdx] = true
+	return Flagged{Object: obj}, nil
+}
+
+func (d *decoder) readValue(typ byte) (Object, error) {
+	switch typ {
+	case typeInt:
+		n, err := d.readInt32()
+		if err != nil {
+			return nil, err
+		}
+		return Int(n), nil
+	case typeInt64:
+		b, err := d.readBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return Int64(binary.LittleEndian.Uint64(b)), nil
+	case typeLong:
+		return d.readLong()
+	case typeBinaryFloat:
+		f, err := d.readFloat64()
+		if err != nil {
+			return nil, err
+		}
+		return Float(f), nil
+	case typeBinaryComplex:
+		re, err := d.readFloat64()
+		if err != nil {
+			return nil, err
+		}
+		im, err := d.readFloat64()
+		if err != nil {
+			return nil, err
+		}
+		return Complex(complex(re, im)), nil
+	case typeString:
+		n, err := d.readSize("bytes object")
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.readBytes(n)
+		if err != nil {
+			return nil, err
+		}
+		return Bytes(append([]byte{}, b...)), nil
+	case typeUnicode, typeInterned:
+		n, err := d.readSize("string")
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.readBytes(n)
+		if err != nil {
+			return nil, err
+		}
+		return String{Value: string(b), Interned: typ == typeInterned}, nil
+	case typeASCII, typeASCIIInterned, typeShortASCII, typeShortASCIIInterned:
+		var n int
+		var err error
+		if typ == typeShortASCII || typ == typeShortASCIIInterned {
+			b, err := d.readByte()
+			if err != nil {
+				return nil, err
+			}
+			n = int(b)
+		} else {
+			n, err = d.readSize("string")
+			if err != nil {
+				return nil, err
+			}
+		}
+		b, err := d.readBytes(n)
+		if err != nil {
+			return nil, err
+		}
+		// CPython doesn't check that "ASCII" strings are ASCII; it decodes them as Latin-1.
+		runes := make([]rune, 0, len(b))
+		for _, c := range b {
+			runes = append(runes, rune(c))
+		}
+		return String{
+			Value:    string(runes),
+			Interned: typ == typeASCIIInterned || typ == typeShortASCIIInterned,
+		}, nil
+	case typeTuple, typeSmallTuple:
+		var n int
+		var err error
+		if typ == typeSmallTuple {
+			b, err := d.readByte()
+			if err != nil {
+				return nil, err
+			}
+			n = int(b)
+		} else {
+			n, err = d.readSize("tuple")
+			if err != nil {
+				return nil, err
+			}
+		}
+		items, err := d.readObjects(n)
+		if err != nil {
+			return nil, err
+		}
+		return Tuple(items), nil
+	case typeList:
+		n, err := d.readSize("list")
+		if err != nil {
+			return nil, err
+		}
+		items, err := d.readObjects(n)
+		if err != nil {
+			return nil, err
+		}
+		return List(items), nil
+	case typeSet, typeFrozenSet:
+		n, err := d.readSize("set")
+		if err != nil {
+			return nil, err
+		}
+		items, err := d.readObjects(n)
+		if err != nil {
+			return nil, err
+		}
+		if typ == typeFrozenSet {
+			return FrozenSet(items), nil
+		}
+		return Set(items), nil
+	case typeDict:
+		dict := Dict{}
+		for {
+			if d.pos < len(d.data) && d.data[d.pos]&^flagRef == typeNull {
+				d.pos++
+				return dict, nil
+			}
+			key, err := d.readObject()
+			if err != nil {
+				return nil, err
+			}
+			val, err := d.readObject()
+			if err != nil {
+				return nil, err
+			}
+			dict = append(dict, DictItem{Key: key, Value: val})
+		}
+	case typeCode:
+		return d.readCode()
+	case typeNull:
+		return nil, d.errorf("NULL object")
+	case typeFloat, typeComplex:
+		return nil, d.errorf("unsupported type code %q (from marshal version <2)", typ)
+	case typeUnknown:
+		return nil, d.errorf("unknown object")
+	default:
+		return nil, d.errorf("unknown type code %q", typ)
+	}
+}
+
+func (d *decoder) readLong() (Object, error) {
+	n, err := d.readInt32()
+	if err != nil {
+		return nil, err
+	}
+	negative := n < 0
+	size := int64(n)
+	if negative {
+		size = -size
+	}
+	if size > int64(len(d.data)-d.pos)/2 {
+		return nil, d.errorf("long size out of range")
+	}
+	// Pack the 15-bit digits into little-endian bytes, and only then build the big.Int; doing
+	// big.Int arithmetic per digit would be quadratic in the size of the number.
+	packed := make([]byte, 0, (15*size+7)/8)
+	var acc uint32
+	var accBits uint
+	for i := int64(0); i < size; i++ {
+		b, err := d.readBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		dig := binary.LittleEndian.Uint16(b)
+		if dig > 0x7fff {
+			return nil, d.errorf("digit out of range in long")
+		}
+		if dig == 0 && i == size-1 {
+			return nil, d.errorf("unnormalized long data")
+		}
+		acc |= uint32(dig) << accBits
+		accBits += 15
+		for accBits >= 8 {
+			packed = append(packed, byte(acc))
+			acc >>= 8
+			accBits -= 8
+		}
+	}
+	if accBits > 0 {
+		packed = append(packed, byte(acc))
+	}
+	// big.Int.SetBytes wants big-endian.
+	for i, j := 0, len(packed)-1; i < j; i, j = i+1, j-1 {
+		packed[i], packed[j] = packed[j], packed[i]
+	}
+	val := new(big.Int).SetBytes(packed)
+	if negative {
+		val.Neg(val)
+	}
+	return Long{Int: val}, nil
+}
+
+func (d *decoder) readCode() (Object, error) {
+	code := &Code{}
+	var err error
+	readInt := func(dst *int32) {
+		if err == nil {
+			*dst, err = d.readInt32()
+		}
+	}
+	readObj := func(dst *Object) {
+		if err == nil {
+			*dst, err = d.readObject()
+		}
+	}
+
+	readInt(&code.ArgCount)
+	if d.minor >= 8 {
+		readInt(&code.PosOnlyArgCount)
+	}
+	readInt(&code.KwOnlyArgCount)
+	if d.minor < 11 {
+		readInt(&code.NLocals)
+	}
+	readInt(&code.StackSize)
+	readInt(&code.Flags)
+	readObj(&code.Code)
+	readObj(&code.Consts)
+	readObj(&code.Names)
+	if d.minor < 11 {
+		readObj(&code.VarNames)
+		readObj(&code.FreeVars)
+		readObj(&code.CellVars)
+	} else {
+		readObj(&code.LocalsPlusNames)
+		readObj(&code.LocalsPlusKinds)
+	}
+	readObj(&code.Filename)
+	readObj(&code.Name)
+	if d.minor >= 11 {
+		readObj(&code.QualName)
+	}
+	readInt(&code.FirstLineNo)
+	readObj(&code.LineTable)
+	if d.minor >= 11 {
+		readObj(&code.ExceptionTable)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return code, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type encoder struct {
+	major, minor int
+
+	buf []byte
+}
+
+// Marshal encodes an object the way that the given version of Python would.
+func Marshal(obj Object, major, minor int) ([]byte, error) {
+	if err := checkVersion(major, minor); err != nil {
+		return nil, err
+	}
+	e := &encoder{
+		major: major,
+		minor: minor,
+	}
+	if err := e.writeObject(obj, 0); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+func (e *encoder) writeByte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *encoder) writeInt32(n int32) {
+	e.buf = append(e.buf, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(e.buf[len(e.buf)-4:], uint32(n))
+}
+
+func (e *encoder) writeFloat64(f float64) {
+	e.buf = append(e.buf, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(e.buf[len(e.buf)-8:], math.Float64bits(f))
+}
+
+func (e *encoder) writeSize(n int) error {
+	if n > math.MaxInt32 {
+		return fmt.Errorf("marshal: object too large: %d", n)
+	}
+	e.writeInt32(int32(n))
+	return nil
+}
+
+func (e *encoder) writeObjects(objs []Object, depth int) error {
+	for _, obj := range objs {
+		if err := e.writeObject(obj, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) writeObject(obj Object, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("marshal: recursion limit exceeded")
+	}
+	flag := byte(0)
+	if flagged, ok := obj.(Flagged); ok {
+		switch flagged.Object.(type) {
+		case None, StopIteration, Ellipsis, Bool, Ref, Flagged:
+			return fmt.Errorf("marshal: %T may not be Flagged", flagged.Object)
+		}
+		obj = flagged.Object
+		flag = flagRef
+	}
+
+	switch obj := obj.(type) {
+	case None:
+		e.writeByte(typeNone)
+	case StopIteration:
+		e.writeByte(typeStopIter)
+	case Ellipsis:
+		e.writeByte(typeEllipsis)
+	case Bool:
+		if obj {
+			e.writeByte(typeTrue)
+		} else {
+			e.writeByte(typeFalse)
+		}
+	case Ref:
+		if obj.Index < 0 || obj.Index > math.MaxInt32 {
+			return fmt.Errorf("marshal: invalid reference: %d", obj.Index)
+		}
+		e.writeByte(typeRef)
+		e.writeInt32(int32(obj.Index))
+	case Int:
+		e.writeByte(typeInt | flag)
+		e.writeInt32(int32(obj))
+	case Int64:
+		e.writeByte(typeInt64 | flag)
+		e.buf = append(e.buf, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(e.buf[len(e.buf)-8:], uint64(obj))
+	case Long:
+		if obj.Int == nil {
+			return fmt.Errorf("marshal: nil Long")
+		}
+		e.writeByte(typeLong | flag)
+		// Split the words of the absolute value into 15-bit digits in a single pass; doing
+		// big.Int arithmetic per digit would be quadratic in the size of the number.
+		words := obj.Bits() // the absolute value, little-endian
+		digits := make([]uint16, 0, (len(words)*bits.UintSize+14)/15)
+		var acc uint32
+		var accBits uint
+		for _, word := range words {
+			for i := 0; i < bits.UintSize; i += 8 {
+				acc |= uint32(byte(word>>uint(i))) << accBits
+				accBits += 8
+				for accBits >= 15 {
+					digits = append(digits, uint16(acc&0x7fff))
+					acc >>= 15
+					accBits -= 15
+				}
+			}
+		}
+		if accBits > 0 {
+			digits = append(digits, uint16(acc))
+		}
+		for len(digits) > 0 && digits[len(digits)-1] == 0 {
+			digits = digits[:len(digits)-1]
+		}
+		n := len(digits)
+		if n > math.MaxInt32 {
+			return fmt.Errorf("marshal: object too large: %d", n)
+		}
+		if obj.Sign() < 0 {
+			e.writeInt32(-int32(n))
+		} else {
+			e.writeInt32(int32(n))
+		}
+		for _, dig := range digits {
+			e.buf = append(e.buf, byte(dig), byte(dig>>8))
+		}
+	case Float:
+		e.writeByte(typeBinaryFloat | flag)
+		e.writeFloat64(float64(obj))
+	case Complex:
+		e.writeByte(typeBinaryComplex | flag)
+		e.writeFloat64(real(obj))
+		e.writeFloat64(imag(obj))
+	case Bytes:
+		e.writeByte(typeString | flag)
+		if err := e.writeSize(len(obj)); err != nil {
+			return err
+		}
+		e.buf = append(e.buf, obj...)
+	case String:
+		isASCII := true
+		for i := 0; i < len(obj.Value); i++ {
+			if obj.Value[i] >= 0x80 {
+				isASCII = false
+				break
+			}
+		}
+		switch {
+		case isASCII && len(obj.Value) < 256:
+			if obj.Interned {
+				e.writeByte(typeShortASCIIInterned | flag)
+			} else {
+				e.writeByte(typeShortASCII | flag)
+			}
+			e.writeByte(byte(len(obj.Value)))
+		case isASCII:
+			if obj.Interned {
+				e.writeByte(typeASCIIInterned | flag)
+			} else {
+				e.writeByte(typeASCII | flag)
+			}
+			if err := e.writeSize(len(obj.Value)); err != nil {
+				return err
+			}
+		default:
+			if obj.Interned {
+				e.writeByte(typeInterned | flag)
+			} else {
+				e.writeByte(typeUnicode | flag)
+			}
+			if err := e.writeSize(len(obj.Value)); err != nil {
+				return err
+			}
+		}
+		e.buf = append(e.buf, obj.Value...)
+	case Tuple:
+		if len(obj) < 256 {
+			e.writeByte(typeSmallTuple | flag)
+			e.writeByte(byte(len(obj)))
+		} else {
+			e.writeByte(typeTuple | flag)
+			if err := e.writeSize(len(obj)); err != nil {
+				return err
+			}
+		}
+		return e.writeObjects(obj, depth+1)
+	case List:
+		e.writeByte(typeList | flag)
+		if err := e.writeSize(len(obj)); err != nil {
+			return err
+		}
+		return e.writeObjects(obj, depth+1)
+	case Set:
+		e.writeByte(typeSet | flag)
+		if err := e.writeSize(len(obj)); err != nil {
+			return err
+		}
+		return e.writeObjects(obj, depth+1)
+	case FrozenSet:
+		e.writeByte(typeFrozenSet | flag)
+		if err := e.writeSize(len(obj)); err != nil {
+			return err
+		}
+		return e.writeObjects(obj, depth+1)
+	case Dict:
+		e.writeByte(typeDict | flag)
+		for _, item := range obj {
+			if err := e.writeObjects([]Object{item.Key, item.Value}, depth+1); err != nil {
+				return err
+			}
+		}
+		e.writeByte(typeNull)
+	case *Code:
+		e.writeByte(typeCode | flag)
+		return e.writeCode(obj, depth+1)
+	case nil:
+		return fmt.Errorf("marshal: nil Object")
+	default:
+		return fmt.Errorf("marshal: unsupported type: %T", obj)
+	}
+	return nil
+}
+
+func (e *encoder) writeCode(code *Code, depth int) error {
+	e.writeInt32(code.ArgCount)
+	if e.minor >= 8 {
+		e.writeInt32(code.PosOnlyArgCount)
+	}
+	e.writeInt32(code.KwOnlyArgCount)
+	if e.minor < 11 {
+		e.writeInt32(code.NLocals)
+	}
+	e.writeInt32(code.StackSize)
+	e.writeInt32(code.Flags)
+	objs := []Object{code.Code, code.Consts, code.Names}
+	if e.minor < 11 {
+		objs = append(objs, code.VarNames, code.FreeVars, code.CellVars)
+	} else {
+		objs = append(objs, code.LocalsPlusNames, code.LocalsPlusKinds)
+	}
+	objs = append(objs, code.Filename, code.Name)
+	if e.minor >= 11 {
+		objs = append(objs, code.QualName)
+	}
+	if err := e.writeObjects(objs, depth); err != nil {
+		return err
+	}
+	e.writeInt32(code.FirstLineNo)
+	objs = []Object{code.LineTable}
+	if e.minor >= 11 {
+		objs = append(objs, code.ExceptionTable)
+	}
+	return e.writeObjects(objs, depth)
+}
@@ -0,0 +1,302 @@
+package marshal_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/layertool/pkg/python/marshal"
+	"github.com/datawire/layertool/pkg/python/pyc"
+)
+
+// unflag strips Flagged wrappers, for comparing objects without caring about CPython's
+// refcount-dependent choice of what to flag.
+func unflag(obj marshal.Object) marshal.Object {
+	unflagAll := func(objs []marshal.Object) []marshal.Object {
+		ret := make([]marshal.Object, 0, len(objs))
+		for _, o := range objs {
+			ret = append(ret, unflag(o))
+		}
+		return ret
+	}
+	switch obj := obj.(type) {
+	case marshal.Flagged:
+		return unflag(obj.Object)
+	case marshal.Tuple:
+		return marshal.Tuple(unflagAll(obj))
+	case marshal.List:
+		return marshal.List(unflagAll(obj))
+	case marshal.Set:
+		return marshal.Set(unflagAll(obj))
+	case marshal.FrozenSet:
+		return marshal.FrozenSet(unflagAll(obj))
+	case marshal.Dict:
+		ret := make(marshal.Dict, 0, len(obj))
+		for _, item := range obj {
+			ret = append(ret, marshal.DictItem{Key: unflag(item.Key), Value: unflag(item.Value)})
+		}
+		return ret
+	default:
+		return obj
+	}
+}
+
+func TestFixtures(t *testing.T) {
+	t.Parallel()
+	filenames, err := filepath.Glob(filepath.Join("..", "pyc", "testdata", "fixture.*-hash.pyc"))
+	require.NoError(t, err)
+	timestamps, err := filepath.Glob(filepath.Join("..", "pyc", "testdata", "fixture.*.timestamp.pyc"))
+	require.NoError(t, err)
+	filenames = append(filenames, timestamps...)
+	require.NotEmpty(t, filenames)
+
+	for _, filename := range filenames {
+		filename := filename
+		t.Run(filepath.Base(filename), func(t *testing.T) {
+			t.Parallel()
+			content, err := ioutil.ReadFile(filename)
+			require.NoError(t, err)
+			hdr, data, err := pyc.ParseHeader(content)
+			require.NoError(t, err)
+			major, minor, _ := hdr.Magic.Version()
+
+			obj, err := marshal.Unmarshal(data, major, minor)
+			require.NoError(t, err)
+
+			code, ok := unflag(obj).(*marshal.Code)
+			require.True(t, ok, "top-level object is a %T", obj)
+			refs := marshal.References(obj)
+			resolve := func(o marshal.Object) marshal.Object {
+				if ref, ok := o.(marshal.Ref); ok {
+					return refs[ref.Index]
+				}
+				return unflag(o)
+			}
+			// Which strings get interned varies between versions, so only compare the values.
+			str := func(o marshal.Object) string {
+				s, ok := resolve(o).(marshal.String)
+				require.True(t, ok, "object is a %T", o)
+				return s.Value
+			}
+			assert.Equal(t, "fixture.py", str(code.Filename))
+			assert.Equal(t, "<module>", str(code.Name))
+			consts, ok := unflag(code.Consts).(marshal.Tuple)
+			require.True(t, ok)
+			assert.Equal(t, "A module that exercises a variety of marshal types.", str(consts[0]))
+
+			reencoded, err := marshal.Marshal(obj, major, minor)
+			require.NoError(t, err)
+			assert.Equal(t, data, reencoded)
+		})
+	}
+}
+
+func TestValues(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not found")
+	}
+	t.Parallel()
+	long := func(s string) marshal.Object {
+		n, _ := new(big.Int).SetString(s, 10)
+		return marshal.Long{Int: n}
+	}
+	testcases := map[string]marshal.Object{
+		`None`:                            marshal.None{},
+		`True`:                            marshal.Bool(true),
+		`False`:                           marshal.Bool(false),
+		`...`:                             marshal.Ellipsis{},
+		`StopIteration`:                   marshal.StopIteration{},
+		`-7`:                              marshal.Int(-7),
+		`2**31-1`:                         marshal.Int(2147483647),
+		`2**70`:                           long("1180591620717411303424"),
+		`-2**40`:                          long("-1099511627776"),
+		`2**45`:                           long("35184372088832"),
+		`-3**500`:                         marshal.Long{Int: new(big.Int).Neg(new(big.Int).Exp(big.NewInt(3), big.NewInt(500), nil))},
+		`1.5`:                             marshal.Float(1.5),
+		`(2-3j)`:                          marshal.Complex(2 - 3i),
+		`b"by\x00tes"`:                    marshal.Bytes("by\x00tes"),
+		`"not interned"`:                  marshal.String{Value: "not interned"},
+		`"x "*150`:                        marshal.String{Value: strings.Repeat("x ", 150)},
+		`"ünïcode"`:                       marshal.String{Value: "ünïcode"},
+		`sys.intern("interned")`:          marshal.String{Value: "interned", Interned: true},
+		`(1, "a b", None)`:                marshal.Tuple{marshal.Int(1), marshal.String{Value: "a b"}, marshal.None{}},
+		`tuple(range(300))`:               marshal.Tuple(intRange(300)),
+		`(0,)*300`:                        marshal.Tuple(append([]marshal.Object{marshal.Int(0)}, repeat(marshal.Ref{Index: 0}, 299)...)),
+		`[[], {}]`:                        marshal.List{marshal.List{}, marshal.Dict{}},
+		`{"k k": 1}`:                      marshal.Dict{{Key: marshal.String{Value: "k k"}, Value: marshal.Int(1)}},
+		`{7}`:                             marshal.Set{marshal.Int(7)},
+		`frozenset({7})`:                  marshal.FrozenSet{marshal.Int(7)},
+		`(lambda x: x).__code__.co_flags`: marshal.Int(3),
+	}
+	for expr, expected := range testcases {
+		expr, expected := expr, expected
+		t.Run(expr, func(t *testing.T) {
+			t.Parallel()
+			data, err := exec.Command("python3", "-c",
+				`import marshal, sys; sys.stdout.buffer.write(marshal.dumps(`+expr+`))`).
+				Output()
+			require.NoError(t, err)
+
+			// Scalars and containers are encoded the same by every supported version.
+			actual, err := marshal.Unmarshal(data, 3, 11)
+			require.NoError(t, err)
+			assert.Equal(t, expected, unflag(actual))
+
+			reencoded, err := marshal.Marshal(actual, 3, 11)
+			require.NoError(t, err)
+			assert.Equal(t, data, reencoded)
+		})
+	}
+}
+
+func intRange(n int) []marshal.Object {
+	ret := make([]marshal.Object, 0, n)
+	for i := 0; i < n; i++ {
+		ret = append(ret, marshal.Int(i))
+	}
+	return ret
+}
+
+func repeat(obj marshal.Object, n int) []marshal.Object {
+	ret := make([]marshal.Object, 0, n)
+	for i := 0; i < n; i++ {
+		ret = append(ret, obj)
+	}
+	return ret
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	t.Parallel()
+	testcases := map[string]struct {
+		Input       string
+		ExpectedErr string
+	}{
+		"empty": {
+			Input:       "",
+			ExpectedErr: "marshal: bad marshal data at offset 0: EOF read where object expected",
+		},
+		"trailing": {
+			Input:       "NN",
+			ExpectedErr: "marshal: 1 bytes of trailing data",
+		},
+		"unknown-type": {
+			Input:       "!",
+			ExpectedErr: `marshal: bad marshal data at offset 1: unknown type code '!'`,
+		},
+		"huge-tuple": {
+			Input:       "(\xff\xff\xff\x7f",
+			ExpectedErr: "marshal: bad marshal data at offset 5: EOF read where object expected",
+		},
+		"negative-list": {
+			Input:       "[\xff\xff\xff\xff",
+			ExpectedErr: "marshal: bad marshal data at offset 5: list size out of range",
+		},
+		"bad-ref": {
+			Input:       "r\x00\x00\x00\x00",
+			ExpectedErr: "marshal: bad marshal data at offset 5: invalid reference",
+		},
+		"ref-to-incomplete-frozenset": {
+			Input:       "\xbe\x01\x00\x00\x00r\x00\x00\x00\x00",
+			ExpectedErr: "marshal: bad marshal data at offset 10: invalid reference",
+		},
+		"unnormalized-long": {
+			Input:       "l\x01\x00\x00\x00\x00\x00",
+			ExpectedErr: "marshal: bad marshal data at offset 7: unnormalized long data",
+		},
+		"deep": {
+			Input:       strings.Repeat("[\x01\x00\x00\x00", 3000) + "N",
+			ExpectedErr: "marshal: bad marshal data at offset 10000: recursion limit exceeded",
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			_, err := marshal.Unmarshal([]byte(tc.Input), 3, 11)
+			assert.EqualError(t, err, tc.ExpectedErr)
+		})
+	}
+
+	_, err := marshal.Unmarshal([]byte("N"), 3, 6)
+	assert.EqualError(t, err, "marshal: unsupported Python version: 3.6")
+}
+
+// hugeLong returns the marshalled form of a long with the given number of 15-bit digits.
+func hugeLong(digits int) []byte {
+	ret := make([]byte, 5, 5+2*digits)
+	ret[0] = 'l'
+	binary.LittleEndian.PutUint32(ret[1:], uint32(digits))
+	for i := 0; i < digits; i++ {
+		ret = append(ret, 0xff, 0x7f)
+	}
+	return ret
+}
+
+// nestedLists returns marshalled data for lists nested depth deep, each of which claims to have
+// as many items as there is data, so that each size passes the check against the input length.
+func nestedLists(depth, size int) []byte {
+	ret := make([]byte, 0, 5*depth+size)
+	for i := 0; i < depth; i++ {
+		ret = append(ret, '[', 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(ret[len(ret)-4:], uint32(size))
+	}
+	return append(ret, bytes.Repeat([]byte("N"), size)...)
+}
+
+// allocated returns how many bytes fn allocates.  It is only meaningful when called from a
+// non-parallel test.
+func allocated(fn func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+// TestUnmarshalResources checks that hostile input can't make Unmarshal or Marshal use time or
+// memory out of proportion to the size of the input.  It doesn't call t.Parallel(), so that
+// other tests' allocations don't get counted.
+func TestUnmarshalResources(t *testing.T) {
+	t.Run("huge-long", func(t *testing.T) {
+		const digits = 1 << 20 // 2MiB of input
+		input := hugeLong(digits)
+		start := time.Now()
+		var obj marshal.Object
+		var encoded []byte
+		alloc := allocated(func() {
+			var err error
+			obj, err = marshal.Unmarshal(input, 3, 11)
+			require.NoError(t, err)
+			encoded, err = marshal.Marshal(obj, 3, 11)
+			require.NoError(t, err)
+		})
+		assert.Less(t, time.Since(start).Seconds(), 5.0)
+		assert.Less(t, alloc, uint64(16*len(input)))
+		require.IsType(t, marshal.Long{}, obj)
+		assert.Equal(t, 15*digits, obj.(marshal.Long).BitLen())
+		assert.Equal(t, input, encoded)
+	})
+	t.Run("nested-lists", func(t *testing.T) {
+		input := nestedLists(1500, 256<<10)
+		start := time.Now()
+		alloc := allocated(func() {
+			_, err := marshal.Unmarshal(input, 3, 11)
+			assert.EqualError(t, err, fmt.Sprintf(
+				"marshal: bad marshal data at offset %d: EOF read where object expected", len(input)))
+		})
+		assert.Less(t, time.Since(start).Seconds(), 5.0)
+		// Trusting the declared sizes would preallocate about 6GiB.
+		assert.Less(t, alloc, uint64(128<<20))
+	})
+}
@@ -0,0 +1,186 @@
+// Package pyc deals with the header of compiled Python bytecode (`.pyc`) files.
+//
+// This mimics the parts of `importlib._bootstrap_external` that deal with pyc headers
+// (`_classify_pyc()`, `_code_to_timestamp_pyc()`, and `_code_to_hash_pyc()`), and only supports
+// the 16-byte header layout used by Python 3.7 and later (PEP 552).
+//
+// https://www.python.org/dev/peps/pep-0552/
+package pyc
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// HeaderSize is the size of a pyc header, in bytes.
+const HeaderSize = 16
+
+// A Magic is the number that identifies the bytecode version of a pyc file.  On disk it is
+// stored as 2 little-endian bytes followed by "\r\n", but that trailer is not included here.
+type Magic uint16
+
+// The blocks of magic numbers reserved for each minor version of Python, from the comments in
+// `Lib/importlib/_bootstrap_external.py`.  A version's releases use numbers from within its
+// block, but not necessarily the last one; for example, 3.7 uses 3394 and 3.12 uses 3531.
+var magicRanges = []struct {
+	Major, Minor int
+	Low, High    Magic
+}{
+	{3, 3, 3190, 3249},
+	{3, 4, 3250, 3319},
+	{3, 5, 3320, 3359},
+	{3, 6, 3360, 3389},
+	{3, 7, 3390, 3399},
+	{3, 8, 3400, 3419},
+	{3, 9, 3420, 3429},
+	{3, 10, 3430, 3449},
+	{3, 11, 3450, 3499},
+	{3, 12, 3500, 3549},
+	{3, 13, 3550, 3599},
+}
+
+// Version returns the Python language version that the magic number belongs to.  ok is false if
+// it isn't a magic number for a version of Python that this package knows about.
+func (m Magic) Version() (major, minor int, ok bool) {
+	for _, r := range magicRanges {
+		if r.Low <= m && m <= r.High {
+			return r.Major, r.Minor, true
+		}
+	}
+	return 0, 0, false
+}
+
+// Flags is the bit field that follows the magic number, and says how the pyc should be checked
+// against its source file.
+type Flags uint32
+
+const (
+	// FlagHashBased says that the header contains a hash of the source file, rather than its
+	// mtime and size.
+	FlagHashBased Flags = 1 << iota
+	// FlagCheckSource says that, for a hash-based pyc, Python should check the hash against the
+	// source file before using the pyc.  It is meaningless without FlagHashBased.
+	FlagCheckSource
+)
+
+// A Header is the parsed form of the 16-byte header at the beginning of a pyc file.
+type Header struct {
+	Magic Magic
+	Flags Flags
+
+	// For timestamp-based pycs (Flags&FlagHashBased == 0).
+	SourceMTime uint32 // seconds since the Unix epoch, truncated to 32 bits
+	SourceSize  uint32 // size of the source file, truncated to 32 bits
+
+	// For hash-based pycs (Flags&FlagHashBased != 0).
+	SourceHash [8]byte // `_imp.source_hash()` of the source file
+}
+
+// ParseHeader parses the header at the beginning of a pyc file, returning the header and the
+// rest of the file (which is the marshalled code object).
+func ParseHeader(pyc []byte) (Header, []byte, error) {
+	if len(pyc) < HeaderSize {
+		return Header{}, nil, fmt.Errorf("pyc: file too short for a header: %d bytes", len(pyc))
+	}
+	if pyc[2] != '\r' || pyc[3] != '\n' {
+		return Header{}, nil, fmt.Errorf("pyc: bad magic number: %q", pyc[:4])
+	}
+	var hdr Header
+	hdr.Magic = Magic(binary.LittleEndian.Uint16(pyc[0:2]))
+	if major, minor, ok := hdr.Magic.Version(); !ok {
+		return Header{}, nil, fmt.Errorf("pyc: unknown magic number: %d", hdr.Magic)
+	} else if major == 3 && minor < 7 {
+		return Header{}, nil, fmt.Errorf("pyc: magic number %d is from Python %d.%d, which has an unsupported header layout",
+			hdr.Magic, major, minor)
+	}
+	hdr.Flags = Flags(binary.LittleEndian.Uint32(pyc[4:8]))
+	if hdr.Flags&^(FlagHashBased|FlagCheckSource) != 0 {
+		return Header{}, nil, fmt.Errorf("pyc: invalid flags: %#x", uint32(hdr.Flags))
+	}
+	if hdr.Flags&FlagHashBased != 0 {
+		copy(hdr.SourceHash[:], pyc[8:16])
+	} else {
+		hdr.SourceMTime = binary.LittleEndian.Uint32(pyc[8:12])
+		hdr.SourceSize = binary.LittleEndian.Uint32(pyc[12:16])
+	}
+	return hdr, pyc[HeaderSize:], nil
+}
+
+// WriteHeader returns the 16-byte serialization of a pyc header.  Fields that aren't relevant to
+// the header's Flags are not written.
+func WriteHeader(hdr Header) []byte {
+	ret := make([]byte, HeaderSize)
+	binary.LittleEndian.PutUint16(ret[0:2], uint16(hdr.Magic))
+	ret[2] = '\r'
+	ret[3] = '\n'
+	binary.LittleEndian.PutUint32(ret[4:8], uint32(hdr.Flags))
+	if hdr.Flags&FlagHashBased != 0 {
+		copy(ret[8:16], hdr.SourceHash[:])
+	} else {
+		binary.LittleEndian.PutUint32(ret[8:12], hdr.SourceMTime)
+		binary.LittleEndian.PutUint32(ret[12:16], hdr.SourceSize)
+	}
+	return ret
+}
+
+// An InvalidationMode is how Python decides whether a pyc file is up to date with its source
+// file; it mimics `py_compile.PycInvalidationMode`.
+type InvalidationMode int
+
+const (
+	InvalidationModeTimestamp InvalidationMode = iota + 1
+	InvalidationModeCheckedHash
+	InvalidationModeUncheckedHash
+)
+
+// String returns the mode's name as spelled by `compileall --invalidation-mode`.
+func (m InvalidationMode) String() string {
+	switch m {
+	case InvalidationModeTimestamp:
+		return "timestamp"
+	case InvalidationModeCheckedHash:
+		return "checked-hash"
+	case InvalidationModeUncheckedHash:
+		return "unchecked-hash"
+	default:
+		return fmt.Sprintf("InvalidationMode(%d)", int(m))
+	}
+}
+
+// InvalidationMode returns the invalidation mode described by the header's Flags.
+func (hdr Header) InvalidationMode() InvalidationMode {
+	switch {
+	case hdr.Flags&FlagHashBased == 0:
+		return InvalidationModeTimestamp
+	case hdr.Flags&FlagCheckSource != 0:
+		return InvalidationModeCheckedHash
+	default:
+		return InvalidationModeUncheckedHash
+	}
+}
+
+// PycInvalidationMode parses the header at the beginning of a pyc file, and returns the
+// invalidation mode that the file was written with.
+func PycInvalidationMode(pyc []byte) (InvalidationMode, error) {
+	hdr, _, err := ParseHeader(pyc)
+	if err != nil {
+		return 0, err
+	}
+	return hdr.InvalidationMode(), nil
+}
+
+// FixPycSourceSize returns a copy of a timestamp-based pyc file with the source-size field of its
+// header set to sourceLen.  This is for keeping the header consistent with a source file that was
+// changed after the pyc was compiled; otherwise Python will see the mismatch and recompile it.
+// Hash-based pyc files don't record the source size, so it is an error to pass one.
+func FixPycSourceSize(pyc []byte, sourceLen uint32) ([]byte, error) {
+	hdr, rest, err := ParseHeader(pyc)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Flags&FlagHashBased != 0 {
+		return nil, fmt.Errorf("pyc: hash-based pyc files don't have a source size")
+	}
+	hdr.SourceSize = sourceLen
+	return append(WriteHeader(hdr), rest...), nil
+}
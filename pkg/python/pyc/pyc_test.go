@@ -0,0 +1,214 @@
+package pyc_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/layertool/pkg/python/pyc"
+)
+
+// These match testdata/generate.sh.
+const (
+	fixtureMTime = 1600000000
+	fixtureSize  = 373
+)
+
+var fixtureTags = map[string]pyc.Magic{
+	"cpython-37":  3394,
+	"cpython-38":  3413,
+	"cpython-39":  3425,
+	"cpython-310": 3439,
+	"cpython-311": 3495,
+	"cpython-312": 3531,
+	"cpython-313": 3571,
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	content, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return content
+}
+
+func TestParseHeader(t *testing.T) {
+	t.Parallel()
+	for tag, magic := range fixtureTags {
+		tag, magic := tag, magic
+		t.Run(tag, func(t *testing.T) {
+			t.Parallel()
+
+			timestamp := readFixture(t, "fixture."+tag+".timestamp.pyc")
+			hdr, rest, err := pyc.ParseHeader(timestamp)
+			require.NoError(t, err)
+			assert.Equal(t, pyc.Header{
+				Magic:       magic,
+				Flags:       0,
+				SourceMTime: fixtureMTime,
+				SourceSize:  fixtureSize,
+			}, hdr)
+			assert.Equal(t, timestamp[pyc.HeaderSize:], rest)
+			assert.Equal(t, timestamp[:pyc.HeaderSize], pyc.WriteHeader(hdr))
+
+			checked := readFixture(t, "fixture."+tag+".checked-hash.pyc")
+			checkedHdr, _, err := pyc.ParseHeader(checked)
+			require.NoError(t, err)
+			assert.Equal(t, magic, checkedHdr.Magic)
+			assert.Equal(t, pyc.FlagHashBased|pyc.FlagCheckSource, checkedHdr.Flags)
+			assert.NotEqual(t, [8]byte{}, checkedHdr.SourceHash)
+			assert.Zero(t, checkedHdr.SourceMTime)
+			assert.Zero(t, checkedHdr.SourceSize)
+			assert.Equal(t, checked[:pyc.HeaderSize], pyc.WriteHeader(checkedHdr))
+
+			unchecked := readFixture(t, "fixture."+tag+".unchecked-hash.pyc")
+			uncheckedHdr, _, err := pyc.ParseHeader(unchecked)
+			require.NoError(t, err)
+			assert.Equal(t, pyc.FlagHashBased, uncheckedHdr.Flags)
+			assert.Equal(t, checkedHdr.SourceHash, uncheckedHdr.SourceHash)
+			assert.Equal(t, unchecked[:pyc.HeaderSize], pyc.WriteHeader(uncheckedHdr))
+
+			major, minor, ok := magic.Version()
+			assert.True(t, ok)
+			assert.Equal(t, fmt.Sprintf("cpython-%d%d", major, minor), tag)
+		})
+	}
+}
+
+func TestParseHeaderErrors(t *testing.T) {
+	t.Parallel()
+	valid := readFixture(t, "fixture.cpython-311.timestamp.pyc")
+	withByte := func(i int, b byte) []byte {
+		ret := append([]byte(nil), valid...)
+		ret[i] = b
+		return ret
+	}
+	testcases := map[string]struct {
+		Input       []byte
+		ExpectedErr string
+	}{
+		"short": {
+			Input:       valid[:15],
+			ExpectedErr: "pyc: file too short for a header: 15 bytes",
+		},
+		"bad-trailer": {
+			Input:       withByte(3, 'x'),
+			ExpectedErr: `pyc: bad magic number: "\xa7\r\rx"`,
+		},
+		"unknown-magic": {
+			Input:       withByte(1, 0xff),
+			ExpectedErr: "pyc: unknown magic number: 65447",
+		},
+		"old-layout": {
+			Input:       readFixture(t, "fixture.cpython-36.pyc"),
+			ExpectedErr: "pyc: magic number 3379 is from Python 3.6, which has an unsupported header layout",
+		},
+		"bad-flags": {
+			Input:       withByte(4, 0x04),
+			ExpectedErr: "pyc: invalid flags: 0x4",
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			_, _, err := pyc.ParseHeader(tc.Input)
+			assert.EqualError(t, err, tc.ExpectedErr)
+		})
+	}
+}
+
+func TestPycInvalidationMode(t *testing.T) {
+	t.Parallel()
+	modes := []pyc.InvalidationMode{
+		pyc.InvalidationModeTimestamp,
+		pyc.InvalidationModeCheckedHash,
+		pyc.InvalidationModeUncheckedHash,
+	}
+	for tag := range fixtureTags {
+		for _, mode := range modes {
+			filename := "fixture." + tag + "." + mode.String() + ".pyc"
+			mode := mode
+			t.Run(filename, func(t *testing.T) {
+				t.Parallel()
+				actual, err := pyc.PycInvalidationMode(readFixture(t, filename))
+				require.NoError(t, err)
+				assert.Equal(t, mode, actual)
+			})
+		}
+	}
+
+	_, err := pyc.PycInvalidationMode(readFixture(t, "fixture.cpython-36.pyc"))
+	assert.EqualError(t, err, "pyc: magic number 3379 is from Python 3.6, which has an unsupported header layout")
+}
+
+func TestFixPycSourceSize(t *testing.T) {
+	t.Parallel()
+	const transformedSize = fixtureSize + 42
+	for tag := range fixtureTags {
+		tag := tag
+		t.Run(tag, func(t *testing.T) {
+			t.Parallel()
+			input := readFixture(t, "fixture."+tag+".timestamp.pyc")
+			output, err := pyc.FixPycSourceSize(input, transformedSize)
+			require.NoError(t, err)
+
+			hdr, rest, err := pyc.ParseHeader(output)
+			require.NoError(t, err)
+			assert.Equal(t, uint32(transformedSize), hdr.SourceSize)
+			assert.Equal(t, uint32(fixtureMTime), hdr.SourceMTime)
+			assert.Equal(t, input[pyc.HeaderSize:], rest)
+			assert.Equal(t, input[:12], output[:12])
+
+			// The input isn't modified.
+			inputHdr, _, err := pyc.ParseHeader(input)
+			require.NoError(t, err)
+			assert.Equal(t, uint32(fixtureSize), inputHdr.SourceSize)
+
+			_, err = pyc.FixPycSourceSize(readFixture(t, "fixture."+tag+".checked-hash.pyc"), transformedSize)
+			assert.EqualError(t, err, "pyc: hash-based pyc files don't have a source size")
+		})
+	}
+}
+
+// TestFixPycSourceSizePython checks FixPycSourceSize against what Python itself writes when the
+// transformed source is compiled.
+func TestFixPycSourceSizePython(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not found")
+	}
+	t.Parallel()
+	out, err := exec.Command("python3", "-c", `import sys; print(sys.implementation.cache_tag)`).Output()
+	require.NoError(t, err)
+	tag := strings.TrimSpace(string(out))
+	if _, ok := fixtureTags[tag]; !ok {
+		t.Skipf("no fixture for python3's cache tag %q", tag)
+	}
+
+	dir, err := ioutil.TempDir("", "pyc-test.")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	source := append(readFixture(t, "fixture.py"), "# transformed\n"...)
+	sourceFile := filepath.Join(dir, "fixture.py")
+	require.NoError(t, ioutil.WriteFile(sourceFile, source, 0644))
+	cmd := exec.Command("python3", "-c", `
+import os, py_compile, sys
+os.utime(sys.argv[1], (1600000000, 1600000000))
+py_compile.compile(sys.argv[1], cfile=sys.argv[1]+"c", dfile="fixture.py", doraise=True,
+                   invalidation_mode=py_compile.PycInvalidationMode.TIMESTAMP)
+`, sourceFile)
+	cmd.Stderr = os.Stderr
+	require.NoError(t, cmd.Run())
+	compiled, err := ioutil.ReadFile(sourceFile + "c")
+	require.NoError(t, err)
+
+	fixed, err := pyc.FixPycSourceSize(readFixture(t, "fixture."+tag+".timestamp.pyc"), uint32(len(source)))
+	require.NoError(t, err)
+	assert.Equal(t, compiled[:pyc.HeaderSize], fixed[:pyc.HeaderSize])
+}
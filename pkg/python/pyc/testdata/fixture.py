@@ -0,0 +1,14 @@
+"""A module that exercises a variety of marshal types."""
+import os
+
+CONSTANT = ("a", 1, None, True, False, 2**70, -2**40, 1.5, 2j, b"bytes", ...)
+
+
+def function(arg, *args, kw=None, **kwargs):
+    "A function docstring, with ünïcode."
+    return [arg, args, kw, kwargs, os.sep, -1, frozenset({1, 2})]
+
+
+class Class:
+    def method(self):
+        return {"key": "value"}
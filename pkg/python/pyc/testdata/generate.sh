@@ -0,0 +1,20 @@
+#!/usr/bin/env bash
+# Regenerate the pyc fixtures from fixture.py.  Requires each of the listed Python versions to be
+# on $PATH (for instance, via pyenv).
+set -euo pipefail
+cd "$(dirname "$0")"
+
+touch -d @1600000000 fixture.py
+for ver in 3.6 3.7 3.8 3.9 3.10 3.11 3.12 3.13; do
+	python$ver - <<'PYTHON'
+import py_compile, sys
+tag = sys.implementation.cache_tag
+if sys.version_info < (3, 7):
+    py_compile.compile('fixture.py', cfile=f'fixture.{tag}.pyc', dfile='fixture.py', doraise=True)
+else:
+    for mode in py_compile.PycInvalidationMode:
+        name = mode.name.lower().replace('_', '-')
+        py_compile.compile('fixture.py', cfile=f'fixture.{tag}.{name}.pyc', dfile='fixture.py',
+                           doraise=True, invalidation_mode=mode)
+PYTHON
+done
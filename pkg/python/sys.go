@@ -0,0 +1,31 @@
+// This file mimics parts of `sys`.
+
+package python
+
+import (
+	"fmt"
+)
+
+// CacheTagFor returns the value that `sys.implementation.cache_tag` has for a given Python
+// implementation and language version, without needing to run that interpreter.  This is the tag
+// that goes in the filenames of `__pycache__/*.pyc` files (PEP 3147).
+//
+// The impl is the `sys.implementation.name` (such as "cpython" or "pypy"), and major and minor are
+// the language version (`sys.version_info`), which for PyPy is not the same as the PyPy version.
+//
+// An empty string is returned for combinations that don't have a cache tag; just as Python uses
+// `None` to indicate that caching is disabled.
+func CacheTagFor(impl string, major, minor int) string {
+	if major < 3 || (major == 3 && minor < 2) {
+		// __pycache__ directories were introduced in Python 3.2.
+		return ""
+	}
+	switch impl {
+	case "cpython":
+		return fmt.Sprintf("cpython-%d%d", major, minor)
+	case "pypy":
+		return fmt.Sprintf("pypy%d%d", major, minor)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,34 @@
+package python_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/layertool/pkg/python"
+)
+
+func TestCacheTagFor(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		Impl   string
+		Major  int
+		Minor  int
+		Output string
+	}{
+		{"cpython", 3, 9, "cpython-39"},
+		{"cpython", 3, 10, "cpython-310"},
+		{"cpython", 3, 11, "cpython-311"},
+		{"cpython", 3, 2, "cpython-32"},
+		{"cpython", 3, 1, ""},
+		{"cpython", 2, 7, ""},
+		{"pypy", 3, 9, "pypy39"},
+		{"pypy", 3, 10, "pypy310"},
+		{"pypy", 2, 7, ""},
+		{"ironpython", 3, 4, ""},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.Output, python.CacheTagFor(tc.Impl, tc.Major, tc.Minor),
+			"CacheTagFor(%q, %d, %d)", tc.Impl, tc.Major, tc.Minor)
+	}
+}
@@ -0,0 +1,55 @@
+// Package reproducible provides helpers for deriving the timestamp to clamp file times to when
+// building reproducibly.
+//
+// https://reproducible-builds.org/docs/source-date-epoch/
+package reproducible
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseSourceDateEpoch parses the value of the SOURCE_DATE_EPOCH environment variable.
+//
+// The specification requires that it be a decimal integer number of seconds since the Unix epoch;
+// as an extension, an RFC 3339 timestamp is also accepted.  It is an error for str to be empty;
+// the caller should check for the variable being unset if that is not an error for them.
+func ParseSourceDateEpoch(str string) (time.Time, error) {
+	if str == "" {
+		return time.Time{}, fmt.Errorf("SOURCE_DATE_EPOCH is empty")
+	}
+	if secs, err := strconv.ParseInt(str, 10, 64); err == nil {
+		if secs < 0 {
+			return time.Time{}, fmt.Errorf("SOURCE_DATE_EPOCH is negative: %q", str)
+		}
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, str); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("SOURCE_DATE_EPOCH is neither an integer nor an RFC 3339 timestamp: %q", str)
+}
+
+// ClampFromGitCommit returns the committer time of the HEAD commit of the Git repository
+// containing dir; which is the usual choice of SOURCE_DATE_EPOCH for a build from a Git checkout.
+func ClampFromGitCommit(dir string) (time.Time, error) {
+	// --no-show-signature, because with `log.showSignature=true` the signature check gets
+	// printed to stdout along with the format.
+	cmd := exec.Command("git", "show", "-s", "--no-show-signature", "--format=%ct", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return time.Time{}, fmt.Errorf("git show: %w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return time.Time{}, fmt.Errorf("git show: %w", err)
+	}
+	secs, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("git show: parsing commit time: %w", err)
+	}
+	return time.Unix(secs, 0).UTC(), nil
+}
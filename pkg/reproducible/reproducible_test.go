@@ -0,0 +1,86 @@
+package reproducible_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/layertool/pkg/reproducible"
+)
+
+func TestParseSourceDateEpoch(t *testing.T) {
+	t.Parallel()
+	testcases := map[string]struct {
+		Input       string
+		Output      time.Time
+		ExpectedErr string
+	}{
+		"integer":  {Input: "1600000000", Output: time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)},
+		"zero":     {Input: "0", Output: time.Unix(0, 0).UTC()},
+		"rfc3339":  {Input: "2020-09-13T08:26:40-04:00", Output: time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)},
+		"empty":    {Input: "", ExpectedErr: "SOURCE_DATE_EPOCH is empty"},
+		"negative": {Input: "-1", ExpectedErr: `SOURCE_DATE_EPOCH is negative: "-1"`},
+		"garbage": {Input: "yesterday",
+			ExpectedErr: `SOURCE_DATE_EPOCH is neither an integer nor an RFC 3339 timestamp: "yesterday"`},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			actual, err := reproducible.ParseSourceDateEpoch(tc.Input)
+			if tc.ExpectedErr != "" {
+				assert.EqualError(t, err, tc.ExpectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Output, actual)
+		})
+	}
+}
+
+func TestClampFromGitCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "reproducible-test.")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+			"GIT_AUTHOR_DATE=@1500000000 +0000", "GIT_COMMITTER_DATE=@1600000000 +0000")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	git("init", "-q")
+	// With this set, `git log` prints signature checks to stdout, even with --format.
+	git("config", "log.showSignature", "true")
+	if _, err := exec.LookPath("ssh-keygen"); err == nil {
+		keyDir, err := ioutil.TempDir("", "reproducible-test.")
+		require.NoError(t, err)
+		defer os.RemoveAll(keyDir)
+		key := filepath.Join(keyDir, "key")
+		out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key).CombinedOutput()
+		require.NoError(t, err, string(out))
+		git("-c", "gpg.format=ssh", "-c", "user.signingKey="+key,
+			"commit", "-q", "--allow-empty", "-S", "-m", "initial")
+	} else {
+		git("commit", "-q", "--allow-empty", "-m", "initial")
+	}
+
+	actual, err := reproducible.ClampFromGitCommit(dir)
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1600000000, 0).UTC(), actual)
+}
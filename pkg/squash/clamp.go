@@ -0,0 +1,90 @@
+package squash
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"time"
+
+	ociv1 "github.com/google/go-containerregistry/pkg/v1"
+	ociv1tarball "github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/datawire/layertool/pkg/tarlayer"
+)
+
+// A ClampMode says how ClampMtimes adjusts timestamps.
+type ClampMode int
+
+const (
+	// ClampMax caps timestamps at the epoch; older timestamps are left alone.  This is what
+	// SOURCE_DATE_EPOCH usually means.
+	ClampMax ClampMode = iota
+	// ClampFixed sets every timestamp to the epoch.
+	ClampFixed
+)
+
+func (m ClampMode) clamp(t, epoch time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	if m == ClampFixed || t.After(epoch) {
+		return epoch
+	}
+	return t
+}
+
+// ClampMtimes returns a copy of a layer with the timestamps of every entry (including
+// directories, symlinks, and whiteout markers) adjusted to the epoch according to the mode.  This
+// is useful after squashing a base layer in with generated files, since files inherited from the
+// base otherwise keep whatever timestamps they had there.
+//
+// Entries are otherwise copied verbatim, in the same order as the input layer.  The access and
+// change times are adjusted too, if the entry has them.
+func ClampMtimes(layer ociv1.Layer, epoch time.Time, mode ClampMode, opts ...ociv1tarball.LayerOption) (ociv1.Layer, error) {
+	if mode != ClampMax && mode != ClampFixed {
+		return nil, fmt.Errorf("invalid ClampMode: %d", mode)
+	}
+
+	tarStream, err := openLayer(layer)
+	if err != nil {
+		return nil, err
+	}
+	defer tarStream.Close()
+	tarReader := tar.NewReader(tarStream)
+
+	return tarlayer.Build(func(w io.Writer) error {
+		tarWriter := tar.NewWriter(w)
+		for {
+			header, err := tarReader.Next()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return fmt.Errorf("reading tar: %w", err)
+			}
+			header.ModTime = mode.clamp(header.ModTime, epoch)
+			header.AccessTime = mode.clamp(header.AccessTime, epoch)
+			header.ChangeTime = mode.clamp(header.ChangeTime, epoch)
+			// archive/tar doesn't let the Header fields override sub-second times in PAX
+			// records, so drop the records and let it regenerate them from the fields.
+			if header.PAXRecords != nil {
+				records := make(map[string]string, len(header.PAXRecords))
+				for k, v := range header.PAXRecords {
+					switch k {
+					case "mtime", "atime", "ctime":
+					default:
+						records[k] = v
+					}
+				}
+				header.PAXRecords = records
+			}
+			if err := tarWriter.WriteHeader(header); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tarWriter, tarReader); err != nil {
+				return fmt.Errorf("reading tar: %w", err)
+			}
+		}
+		return tarWriter.Close()
+	}, opts...)
+}
@@ -0,0 +1,87 @@
+package squash
+
+import (
+	"archive/tar"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClampMtimes(t *testing.T) {
+	t.Parallel()
+	epoch := time.Unix(1600000000, 0)
+	old := time.Unix(1000000000, 0)
+	recent := time.Unix(1700000000, 0)
+	subsecond := time.Unix(1700000000, 500000000)
+
+	input := EntriesToLayer(t,
+		TestEntry{Header: &tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: recent}},
+		TestEntry{Header: &tar.Header{Name: "dir/.wh.gone", Typeflag: tar.TypeReg, Mode: 0644, ModTime: recent}},
+		TestEntry{Header: &tar.Header{Name: "dir/old", Typeflag: tar.TypeReg, Mode: 0644, ModTime: old}},
+		TestEntry{Header: &tar.Header{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "old", ModTime: recent}},
+		TestEntry{Header: &tar.Header{Name: "dir/pax", Typeflag: tar.TypeReg, Mode: 0644,
+			ModTime: subsecond, AccessTime: subsecond,
+			Format: tar.FormatPAX, PAXRecords: map[string]string{"SCHILY.xattr.user.k": "v"}}},
+	)
+
+	testcases := map[string]struct {
+		Mode     ClampMode
+		Expected map[string]time.Time
+	}{
+		"max": {
+			Mode: ClampMax,
+			Expected: map[string]time.Time{
+				"dir/":         epoch,
+				"dir/.wh.gone": epoch,
+				"dir/old":      old,
+				"dir/link":     epoch,
+				"dir/pax":      epoch,
+			},
+		},
+		"fixed": {
+			Mode: ClampFixed,
+			Expected: map[string]time.Time{
+				"dir/":         epoch,
+				"dir/.wh.gone": epoch,
+				"dir/old":      epoch,
+				"dir/link":     epoch,
+				"dir/pax":      epoch,
+			},
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			layer, err := ClampMtimes(input, epoch, tc.Mode)
+			require.NoError(t, err)
+
+			layerReader, err := layer.Uncompressed()
+			require.NoError(t, err)
+			defer layerReader.Close()
+			tarReader := tar.NewReader(layerReader)
+			var names []string
+			for {
+				header, err := tarReader.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				names = append(names, header.Name)
+				assert.True(t, tc.Expected[header.Name].Equal(header.ModTime),
+					"%s: mtime %v", header.Name, header.ModTime)
+				if header.Name == "dir/pax" {
+					assert.True(t, epoch.Equal(header.AccessTime), "atime %v", header.AccessTime)
+					assert.Equal(t, "v", header.PAXRecords["SCHILY.xattr.user.k"])
+				}
+			}
+			assert.Equal(t, []string{"dir/", "dir/.wh.gone", "dir/old", "dir/link", "dir/pax"}, names)
+		})
+	}
+
+	_, err := ClampMtimes(input, epoch, ClampMode(7))
+	assert.EqualError(t, err, "invalid ClampMode: 7")
+}
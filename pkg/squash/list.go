@@ -0,0 +1,68 @@
+package squash
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+
+	ociv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// EntryInfo is the metadata of a single entry in a layer, as returned by ListLayer.
+type EntryInfo struct {
+	Name     string // path.Clean()'d, so directories do NOT have a trailing "/"
+	Type     byte   // a tar.Type* constant
+	Mode     int64  // permission, setuid, setgid, and sticky bits: tar.Header.Mode&07777
+	Size     int64
+	Linkname string     // for symlinks and hardlinks
+	Digest   ociv1.Hash // the sha256 of the content, for regular files; zero otherwise
+}
+
+// ListLayer returns the metadata of every entry in a layer, sorted by name.  The layer is read
+// once, and file contents are hashed as they are streamed, rather than being held in memory.
+// Whiteout markers are listed like any other entry.
+func ListLayer(layer ociv1.Layer) ([]EntryInfo, error) {
+	tarStream, err := openLayer(layer)
+	if err != nil {
+		return nil, err
+	}
+	defer tarStream.Close()
+	tarReader := tar.NewReader(tarStream)
+
+	var ret []EntryInfo
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("reading tar: %w", err)
+		}
+		entry := EntryInfo{
+			Name:     path.Clean(header.Name),
+			Type:     header.Typeflag,
+			Mode:     header.Mode & 07777,
+			Size:     header.Size,
+			Linkname: header.Linkname,
+		}
+		if header.Typeflag == tar.TypeReg {
+			hasher := sha256.New()
+			if _, err := io.Copy(hasher, tarReader); err != nil {
+				return nil, fmt.Errorf("reading tar: %w", err)
+			}
+			entry.Digest = ociv1.Hash{
+				Algorithm: "sha256",
+				Hex:       hex.EncodeToString(hasher.Sum(nil)),
+			}
+		}
+		ret = append(ret, entry)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
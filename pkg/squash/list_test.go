@@ -0,0 +1,44 @@
+package squash
+
+import (
+	"archive/tar"
+	"testing"
+
+	ociv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListLayer(t *testing.T) {
+	t.Parallel()
+
+	input := EntriesToLayer(t,
+		TestEntry{Header: &tar.Header{Name: "./usr/bin/hello", Typeflag: tar.TypeReg, Mode: 0755}, Body: "hello\n"},
+		TestEntry{Header: &tar.Header{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755}},
+		TestEntry{Header: &tar.Header{Name: "usr/bin/hi", Typeflag: tar.TypeSymlink, Mode: 0777, Linkname: "hello"}},
+		TestEntry{Header: &tar.Header{Name: "usr/bin/.wh.bye", Typeflag: tar.TypeReg, Mode: 0644}},
+		// Some tar writers include the c_IS* file-type bits in the mode.
+		TestEntry{Header: &tar.Header{Name: "usr/bin/typed", Typeflag: tar.TypeReg, Mode: 0100644}},
+		TestEntry{Header: &tar.Header{Name: "tmp/", Typeflag: tar.TypeDir, Mode: 041777}},
+	)
+
+	actual, err := ListLayer(input)
+	require.NoError(t, err)
+	assert.Equal(t, []EntryInfo{
+		{Name: "tmp", Type: tar.TypeDir, Mode: 01777},
+		{Name: "usr", Type: tar.TypeDir, Mode: 0755},
+		{Name: "usr/bin/.wh.bye", Type: tar.TypeReg, Mode: 0644, Digest: ociv1.Hash{
+			Algorithm: "sha256",
+			Hex:       "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		}},
+		{Name: "usr/bin/hello", Type: tar.TypeReg, Mode: 0755, Size: 6, Digest: ociv1.Hash{
+			Algorithm: "sha256",
+			Hex:       "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03",
+		}},
+		{Name: "usr/bin/hi", Type: tar.TypeSymlink, Mode: 0777, Linkname: "hello"},
+		{Name: "usr/bin/typed", Type: tar.TypeReg, Mode: 0644, Digest: ociv1.Hash{
+			Algorithm: "sha256",
+			Hex:       "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		}},
+	}, actual)
+}
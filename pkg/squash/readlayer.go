@@ -2,6 +2,9 @@ package squash
 
 import (
 	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,6 +12,7 @@ import (
 	"strings"
 
 	ociv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/klauspost/compress/zstd"
 )
 
 type fileEntry struct {
@@ -21,18 +25,92 @@ type layerFS struct {
 	Files           []fileEntry
 }
 
+var (
+	magicTar   = []byte("ustar") // at offset 257
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicZstd  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicBzip2 = []byte("BZh")
+	magicXz    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// decompress sniffs the first few bytes of a layer stream to detect compression, and returns a
+// reader for the uncompressed tar.
+//
+// Layer.Uncompressed() isn't always good enough on its own: a bare tarball may be compressed with
+// something that go-containerregistry doesn't recognize (such as zstd), and there may not be a
+// media type to say so; in which case it passes the compressed bytes through as-is.  So gzip and
+// zstd are transparently decompressed, other codecs that we recognize are rejected, and anything
+// else is assumed to be a raw tar.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	bufReader := bufio.NewReader(r)
+	magic, err := bufReader.Peek(257 + len(magicTar))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case len(magic) >= 257 && bytes.HasPrefix(magic[257:], magicTar):
+		return ioutil.NopCloser(bufReader), nil
+	case bytes.HasPrefix(magic, magicGzip):
+		return gzip.NewReader(bufReader)
+	case bytes.HasPrefix(magic, magicZstd):
+		zstdReader, err := zstd.NewReader(bufReader)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReader.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, magicBzip2):
+		return nil, fmt.Errorf("unsupported compression: bzip2")
+	case bytes.HasPrefix(magic, magicXz):
+		return nil, fmt.Errorf("unsupported compression: xz")
+	default:
+		return ioutil.NopCloser(bufReader), nil
+	}
+}
+
+// openLayer returns a reader for the uncompressed tar stream of a layer.
+func openLayer(layer ociv1.Layer) (io.ReadCloser, error) {
+	layerReader, err := layer.Uncompressed()
+	if err != nil {
+		return nil, fmt.Errorf("reading layer contents: %w", err)
+	}
+	tarStream, err := decompress(layerReader)
+	if err != nil {
+		_ = layerReader.Close()
+		return nil, fmt.Errorf("reading layer contents: %w", err)
+	}
+	return &multiCloser{
+		Reader:  tarStream,
+		closers: []io.Closer{tarStream, layerReader},
+	}, nil
+}
+
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (mc *multiCloser) Close() error {
+	var firstErr error
+	for _, closer := range mc.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 // parseLayer parses a Layer in to a filesystem object, with the following sanitizations made for
 // consistent querying:
 //
 //  - Paths always path.Clean()'d (notably, directories do NOT contain trailing "/").
 func parseLayer(layer ociv1.Layer) (*layerFS, error) {
 	fs := &layerFS{}
-	layerReader, err := layer.Uncompressed()
+	tarStream, err := openLayer(layer)
 	if err != nil {
-		return nil, fmt.Errorf("reading layer contents: %w", err)
+		return nil, err
 	}
-	defer layerReader.Close()
-	tarReader := tar.NewReader(layerReader)
+	defer tarStream.Close()
+	tarReader := tar.NewReader(tarStream)
 	for {
 		header, err := tarReader.Next()
 		if err != nil {
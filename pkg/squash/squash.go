@@ -3,12 +3,15 @@ package squash
 
 import (
 	"archive/tar"
-	"bytes"
+	"compress/gzip"
+	"fmt"
 	"io"
-	"io/ioutil"
+	"strings"
 
 	ociv1 "github.com/google/go-containerregistry/pkg/v1"
 	ociv1tarball "github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/datawire/layertool/pkg/tarlayer"
 )
 
 // Squash multiple layers together in to a single layer.
@@ -17,7 +20,128 @@ import (
 //
 //  1. Includes whiteout markers in the output, since we don't assume to have the root layer.
 //  2. Squash properly implmenets "opaque whiteouts", which go-containerregistry doesn't support.
+//
+// The uncompressed output is a deterministic function of the input layers: entries are written in
+// a fixed order (whiteouts first, then sorted by name), and each entry's header is copied verbatim
+// from the input.  How those headers are serialized is up to Go's archive/tar (which format it
+// picks for each header, the names it gives PAX extended headers, and so on); it writes PAX
+// records sorted by key, and TestSquashGolden checks that none of this changes between Go
+// versions.  The compressed output is produced by go-containerregistry with compress/gzip, which
+// makes no promises about being stable between Go versions; so compare layers by DiffID, not by
+// Digest.
 func Squash(layers []ociv1.Layer, opts ...ociv1tarball.LayerOption) (ociv1.Layer, error) {
+	return Squasher{}.Squash(layers, opts...)
+}
+
+// A Squasher squashes layers together, with settings for how layers get read and written.  The
+// zero value squashes exactly the same way as the Squash function.
+type Squasher struct {
+	// WhiteoutPrefix is the filename prefix that marks a deleted file in the output; if empty,
+	// the OCI ".wh." is used.
+	WhiteoutPrefix string
+	// OpaqueMarker is the filename that marks a directory's contents as deleted in the output
+	// (an "opaque whiteout"); if empty, the OCI ".wh..wh..opq" is used.
+	//
+	// It is an error for a file that isn't a whiteout in the input to be named such that it
+	// would be read as one in the output.
+	OpaqueMarker string
+
+	// RejectSpecialFiles makes it an error for an input layer to contain a character device,
+	// block device, or FIFO.  Otherwise those are kept, with the same device numbers and such,
+	// like any other file.
+	RejectSpecialFiles bool
+
+	// MaxSize, if positive, is the largest that the uncompressed output may be, in bytes; if it
+	// would be larger, then Squash returns an *ErrLayerTooLarge.
+	MaxSize int64
+
+	// Compressor, if set, is used to gzip the output (for example, klauspostgzip.Compress),
+	// instead of go-containerregistry's compress/gzip; in that case any compression-related
+	// LayerOptions are ignored.
+	Compressor tarlayer.Compressor
+	// CompressionLevel, if set, is the gzip level (gzip.HuffmanOnly through
+	// gzip.BestCompression) to compress the output at, whether that is done by the Compressor
+	// or by go-containerregistry (overriding any ociv1tarball.WithCompressionLevel).  If nil,
+	// then a Compressor is called with gzip.BestSpeed, to match go-containerregistry's default;
+	// BenchmarkSquasherCompressionLevel shows the tradeoff.
+	CompressionLevel *int
+}
+
+// ErrLayerTooLarge is the error returned when a squashed layer is bigger than Squasher.MaxSize.
+type ErrLayerTooLarge struct {
+	Limit  int64 // Squasher.MaxSize
+	Actual int64 // the uncompressed size that the layer would have been
+}
+
+func (e *ErrLayerTooLarge) Error() string {
+	return fmt.Sprintf("squashed layer is too large: %d bytes uncompressed, but the limit is %d bytes",
+		e.Actual, e.Limit)
+}
+
+// limitWriter counts the bytes written to it, and passes them through to the underlying writer
+// only until the limit is exceeded; after that it keeps counting, but discards them.  That way
+// an oversized layer isn't held in memory, but the size it would have been is still known.
+type limitWriter struct {
+	w     io.Writer
+	limit int64
+	n     int64
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	lw.n += int64(len(p))
+	if lw.limit > 0 && lw.n > lw.limit {
+		return len(p), nil
+	}
+	return lw.w.Write(p)
+}
+
+var specialFileTypes = map[byte]string{
+	tar.TypeChar:  "character device",
+	tar.TypeBlock: "block device",
+	tar.TypeFifo:  "FIFO",
+}
+
+func (s Squasher) whiteoutFormat() (whiteoutFormat, error) {
+	format := whiteoutFormat{
+		Prefix: s.WhiteoutPrefix,
+		Opaque: s.OpaqueMarker,
+	}
+	if format.Prefix == "" {
+		format.Prefix = ociWhiteoutPrefix
+	}
+	if format.Opaque == "" {
+		format.Opaque = ociOpaqueMarker
+	}
+	// A prefix of "." or ".." would make every dotfile a whiteout.
+	if strings.Contains(format.Prefix, "/") || format.Prefix == "." || format.Prefix == ".." {
+		return whiteoutFormat{}, fmt.Errorf("invalid WhiteoutPrefix: %q", format.Prefix)
+	}
+	if strings.Contains(format.Opaque, "/") || format.Opaque == "." || format.Opaque == ".." {
+		return whiteoutFormat{}, fmt.Errorf("invalid OpaqueMarker: %q", format.Opaque)
+	}
+	// Otherwise the opaque marker can't be told apart from a whiteout of the file named by the
+	// rest of it.  The OCI format has that ambiguity too, but OCI readers special-case it.
+	ociFormat := whiteoutFormat{Prefix: ociWhiteoutPrefix, Opaque: ociOpaqueMarker}
+	if strings.HasPrefix(format.Opaque, format.Prefix) && format != ociFormat {
+		return whiteoutFormat{}, fmt.Errorf("OpaqueMarker %q is ambiguous with WhiteoutPrefix %q",
+			format.Opaque, format.Prefix)
+	}
+	return format, nil
+}
+
+// Squash multiple layers together in to a single layer, as the Squash function does.  The input
+// layers must use the OCI whiteout format, regardless of the Squasher's settings.
+func (s Squasher) Squash(layers []ociv1.Layer, opts ...ociv1tarball.LayerOption) (ociv1.Layer, error) {
+	format, err := s.whiteoutFormat()
+	if err != nil {
+		return nil, err
+	}
+	if s.CompressionLevel != nil {
+		if err := tarlayer.CheckLevel(*s.CompressionLevel); err != nil {
+			return nil, fmt.Errorf("CompressionLevel: %w", err)
+		}
+	}
+
 	root := &fsfile{
 		name: ".",
 	}
@@ -28,26 +152,48 @@ func Squash(layers []ociv1.Layer, opts ...ociv1tarball.LayerOption) (ociv1.Layer
 			return nil, err
 		}
 		for _, wh := range layerFS.WhiteoutMarkers {
-			fsGet(root, wh.Header.Name).Set(wh.Header, wh.Body)
+			file, err := fsGet(root, wh.Header.Name)
+			if err != nil {
+				return nil, err
+			}
+			file.Set(wh.Header, wh.Body)
 		}
-		for _, file := range layerFS.Files {
-			fsGet(root, file.Header.Name).Set(file.Header, file.Body)
+		for _, entry := range layerFS.Files {
+			if typeName, isSpecial := specialFileTypes[entry.Header.Typeflag]; isSpecial && s.RejectSpecialFiles {
+				return nil, fmt.Errorf("layer contains a %s: %q", typeName, entry.Header.Name)
+			}
+			file, err := fsGet(root, entry.Header.Name)
+			if err != nil {
+				return nil, err
+			}
+			file.Set(entry.Header, entry.Body)
 		}
 	}
 
 	// Generate the layer tarball
-	var byteWriter bytes.Buffer
-	tarWriter := tar.NewWriter(&byteWriter)
-	if err := root.WriteTo(".", tarWriter); err != nil {
-		return nil, err
+	write := func(w io.Writer) error {
+		sizeWriter := &limitWriter{w: w, limit: s.MaxSize}
+		tarWriter := tar.NewWriter(sizeWriter)
+		if err := root.WriteTo(".", tarWriter, format); err != nil {
+			return err
+		}
+		if err := tarWriter.Close(); err != nil {
+			return err
+		}
+		if s.MaxSize > 0 && sizeWriter.n > s.MaxSize {
+			return &ErrLayerTooLarge{
+				Limit:  s.MaxSize,
+				Actual: sizeWriter.n,
+			}
+		}
+		return nil
 	}
-	if err := tarWriter.Close(); err != nil {
-		return nil, err
+	if s.Compressor == nil && s.CompressionLevel == nil {
+		return tarlayer.Build(write, opts...)
 	}
-
-	// Wrap that in to a Layer object
-	byteSlice := byteWriter.Bytes()
-	return ociv1tarball.LayerFromOpener(func() (io.ReadCloser, error) {
-		return ioutil.NopCloser(bytes.NewReader(byteSlice)), nil
-	}, opts...)
+	level := gzip.BestSpeed
+	if s.CompressionLevel != nil {
+		level = *s.CompressionLevel
+	}
+	return tarlayer.BuildCompressed(write, s.Compressor, level, opts...)
 }
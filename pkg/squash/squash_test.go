@@ -3,15 +3,29 @@ package squash
 import (
 	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	ociv1 "github.com/google/go-containerregistry/pkg/v1"
 	ociv1tarball "github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/layertool/pkg/tarlayer"
+	"github.com/datawire/layertool/pkg/tarlayer/klauspostgzip"
 )
 
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata/")
+
 type TestFile struct {
 	Name     string
 	Type     byte
@@ -53,27 +67,54 @@ func ParseTestLayer(t *testing.T, layer ociv1.Layer) TestLayer {
 	return ret
 }
 
-func (tl TestLayer) ToLayer(t *testing.T) ociv1.Layer {
-	var byteWriter bytes.Buffer
-	tarWriter := tar.NewWriter(&byteWriter)
+func (tl TestLayer) ToTar(t *testing.T) []byte {
+	entries := make([]TestEntry, 0, len(tl))
 	for _, file := range tl {
-		header := &tar.Header{
+		entries = append(entries, TestEntry{Header: &tar.Header{
 			Name:     file.Name,
 			Typeflag: file.Type,
 			Linkname: file.Linkname,
-			Size:     0,
 			Mode:     0644,
+		}})
+	}
+	return EntriesToTar(t, entries...)
+}
+
+// A TestEntry is a tar entry, for tests that need more control over the headers than a TestLayer
+// gives.  The Header's Size is filled in from the Body.
+type TestEntry struct {
+	Header *tar.Header
+	Body   string
+}
+
+func EntriesToTar(t testing.TB, entries ...TestEntry) []byte {
+	var byteWriter bytes.Buffer
+	tarWriter := tar.NewWriter(&byteWriter)
+	for _, ent := range entries {
+		header := *ent.Header
+		header.Size = int64(len(ent.Body))
+		if err := tarWriter.WriteHeader(&header); err != nil {
+			t.Fatal(err)
 		}
-		if err := tarWriter.WriteHeader(header); err != nil {
+		if _, err := io.WriteString(tarWriter, ent.Body); err != nil {
 			t.Fatal(err)
 		}
 	}
 	if err := tarWriter.Close(); err != nil {
 		t.Fatal(err)
 	}
+	return byteWriter.Bytes()
+}
+
+func EntriesToLayer(t *testing.T, entries ...TestEntry) ociv1.Layer {
+	return BytesToLayer(t, EntriesToTar(t, entries...))
+}
+
+func (tl TestLayer) ToLayer(t *testing.T) ociv1.Layer {
+	return BytesToLayer(t, tl.ToTar(t))
+}
 
-	// Wrap that in to a Layer object
-	byteSlice := byteWriter.Bytes()
+func BytesToLayer(t testing.TB, byteSlice []byte) ociv1.Layer {
 	ret, err := ociv1tarball.LayerFromOpener(func() (io.ReadCloser, error) {
 		return ioutil.NopCloser(bytes.NewReader(byteSlice)), nil
 	})
@@ -87,8 +128,9 @@ func TestSquash(t *testing.T) {
 	t.Parallel()
 
 	testcases := map[string]struct {
-		Input  []TestLayer
-		Output TestLayer
+		Input       []TestLayer
+		Output      TestLayer
+		ExpectedErr string
 	}{
 		"sanitize": {
 			Input: []TestLayer{
@@ -243,6 +285,74 @@ func TestSquash(t *testing.T) {
 				{Name: "tgt", Type: tar.TypeReg},
 			},
 		},
+		"symlink-6": {
+			Input: []TestLayer{
+				{
+					{Name: "dir", Type: tar.TypeDir},
+					{Name: "dir/lnk", Type: tar.TypeSymlink, Linkname: "/../../tgt"}, // can't escape "/"
+				},
+				{
+					{Name: "dir/lnk", Type: tar.TypeReg},
+				},
+			},
+			Output: TestLayer{
+				{Name: "dir/", Type: tar.TypeDir},
+				{Name: "dir/lnk", Type: tar.TypeSymlink, Linkname: "/../../tgt"},
+				{Name: "tgt", Type: tar.TypeReg},
+			},
+		},
+		"symlink-loop-untouched": {
+			Input: []TestLayer{
+				{
+					{Name: "self", Type: tar.TypeSymlink, Linkname: "self"},
+					{Name: "a", Type: tar.TypeSymlink, Linkname: "b"},
+					{Name: "b", Type: tar.TypeSymlink, Linkname: "/a"},
+				},
+				{
+					{Name: "other", Type: tar.TypeReg},
+				},
+			},
+			Output: TestLayer{
+				{Name: "a", Type: tar.TypeSymlink, Linkname: "b"},
+				{Name: "b", Type: tar.TypeSymlink, Linkname: "/a"},
+				{Name: "other", Type: tar.TypeReg},
+				{Name: "self", Type: tar.TypeSymlink, Linkname: "self"},
+			},
+		},
+		"symlink-loop-1": {
+			Input: []TestLayer{
+				{
+					{Name: "self", Type: tar.TypeSymlink, Linkname: "self"},
+				},
+				{
+					{Name: "self", Type: tar.TypeReg},
+				},
+			},
+			ExpectedErr: `resolving "self": too many levels of symbolic links`,
+		},
+		"symlink-loop-2": {
+			Input: []TestLayer{
+				{
+					{Name: "a", Type: tar.TypeSymlink, Linkname: "b"},
+					{Name: "b", Type: tar.TypeSymlink, Linkname: "/a"},
+				},
+				{
+					{Name: "a/file", Type: tar.TypeReg},
+				},
+			},
+			ExpectedErr: `resolving "a/file": too many levels of symbolic links`,
+		},
+		"symlink-loop-3": {
+			Input: []TestLayer{
+				{
+					{Name: "dir", Type: tar.TypeSymlink, Linkname: "/dir/sub"},
+				},
+				{
+					{Name: "dir/file", Type: tar.TypeReg},
+				},
+			},
+			ExpectedErr: `resolving "dir/file": too many levels of symbolic links`,
+		},
 	}
 
 	for tcName, tc := range testcases {
@@ -256,6 +366,10 @@ func TestSquash(t *testing.T) {
 			}
 
 			actual, err := Squash(input)
+			if tc.ExpectedErr != "" {
+				assert.EqualError(t, err, tc.ExpectedErr)
+				return
+			}
 			if !assert.NoError(t, err) {
 				return
 			}
@@ -264,3 +378,429 @@ func TestSquash(t *testing.T) {
 		})
 	}
 }
+
+func TestSquashCompression(t *testing.T) {
+	t.Parallel()
+
+	input := TestLayer{
+		{Name: "dir", Type: tar.TypeDir},
+		{Name: "dir/file", Type: tar.TypeReg},
+	}
+	output := TestLayer{
+		{Name: "dir/", Type: tar.TypeDir},
+		{Name: "dir/file", Type: tar.TypeReg},
+	}
+
+	gzipBytes := func(t *testing.T, in []byte) []byte {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(in); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	zstdBytes := func(t *testing.T, in []byte) []byte {
+		var buf bytes.Buffer
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(in); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	testcases := map[string]struct {
+		Input       func(*testing.T) []byte
+		ExpectedErr string
+	}{
+		"raw": {
+			Input: func(t *testing.T) []byte { return input.ToTar(t) },
+		},
+		"gzip": {
+			// go-containerregistry undoes the first layer of gzip itself; make sure that we
+			// get the second one.
+			Input: func(t *testing.T) []byte { return gzipBytes(t, gzipBytes(t, input.ToTar(t))) },
+		},
+		"zstd": {
+			Input: func(t *testing.T) []byte { return zstdBytes(t, input.ToTar(t)) },
+		},
+		"bzip2": {
+			Input:       func(t *testing.T) []byte { return []byte("BZh91AY&SY") },
+			ExpectedErr: "reading layer contents: unsupported compression: bzip2",
+		},
+	}
+
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := Squash([]ociv1.Layer{BytesToLayer(t, tc.Input(t))})
+			if tc.ExpectedErr != "" {
+				assert.EqualError(t, err, tc.ExpectedErr)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, output, ParseTestLayer(t, actual))
+		})
+	}
+}
+
+// TestSquashGolden checks that the uncompressed output is byte-for-byte what it was when the golden
+// file was generated, to catch changes in archive/tar's serialization between Go versions.  If a
+// change in the output is intentional, regenerate the golden file with `go test -update`.
+func TestSquashGolden(t *testing.T) {
+	t.Parallel()
+
+	modTime := time.Unix(1600000000, 0)
+	input := []ociv1.Layer{
+		EntriesToLayer(t,
+			TestEntry{Header: &tar.Header{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: modTime}},
+			TestEntry{Header: &tar.Header{Name: "usr/hello", Typeflag: tar.TypeReg, Mode: 0755, ModTime: modTime,
+				Uid: 1000, Gid: 1000, Uname: "user", Gname: "group"}, Body: "#!/bin/sh\necho hello\n"},
+			TestEntry{Header: &tar.Header{Name: "usr/link", Typeflag: tar.TypeSymlink, Linkname: "hello",
+				Mode: 0777, ModTime: modTime}},
+			TestEntry{Header: &tar.Header{Name: "usr/xattrs", Typeflag: tar.TypeReg, Mode: 0644, ModTime: modTime,
+				Format: tar.FormatPAX, PAXRecords: map[string]string{
+					"SCHILY.xattr.user.zzz": "last",
+					"SCHILY.xattr.user.aaa": "first",
+					"SCHILY.xattr.user.mmm": "middle",
+				}}, Body: "xattrs\n"},
+			TestEntry{Header: &tar.Header{Name: "usr/share/" + strings.Repeat("long-name-", 12), Typeflag: tar.TypeReg,
+				Mode: 0644, ModTime: modTime.Add(500 * time.Millisecond)}, Body: "long\n"},
+		),
+		EntriesToLayer(t,
+			TestEntry{Header: &tar.Header{Name: "usr/.wh.link", Typeflag: tar.TypeReg, Mode: 0644, ModTime: modTime}},
+			TestEntry{Header: &tar.Header{Name: "opt/app", Typeflag: tar.TypeDir, Mode: 0755, ModTime: modTime}},
+			TestEntry{Header: &tar.Header{Name: "opt/app/.wh..wh..opq", Typeflag: tar.TypeReg, Mode: 0644, ModTime: modTime}},
+		),
+	}
+
+	layer, err := Squash(input)
+	require.NoError(t, err)
+	reader, err := layer.Uncompressed()
+	require.NoError(t, err)
+	defer reader.Close()
+	actual, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+
+	goldenFile := filepath.Join("testdata", "golden.tar")
+	if *updateGolden {
+		require.NoError(t, ioutil.WriteFile(goldenFile, actual, 0644))
+	}
+	expected, err := ioutil.ReadFile(goldenFile)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// Explicitly asking for the OCI whiteout format is the same as the default.
+	layer, err = Squasher{WhiteoutPrefix: ".wh.", OpaqueMarker: ".wh..wh..opq"}.Squash(input)
+	require.NoError(t, err)
+	reader, err = layer.Uncompressed()
+	require.NoError(t, err)
+	defer reader.Close()
+	actual, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestSquasherSpecialFiles(t *testing.T) {
+	t.Parallel()
+
+	input := []ociv1.Layer{EntriesToLayer(t,
+		TestEntry{Header: &tar.Header{Name: "dev/", Typeflag: tar.TypeDir, Mode: 0755}},
+		TestEntry{Header: &tar.Header{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3}},
+		TestEntry{Header: &tar.Header{Name: "dev/sda", Typeflag: tar.TypeBlock, Mode: 0660, Devmajor: 8, Devminor: 0}},
+		TestEntry{Header: &tar.Header{Name: "run/fifo", Typeflag: tar.TypeFifo, Mode: 0600}},
+	)}
+
+	// By default, they're kept as-is.
+	layer, err := Squash(input)
+	require.NoError(t, err)
+	reader, err := layer.Uncompressed()
+	require.NoError(t, err)
+	defer reader.Close()
+	tarReader := tar.NewReader(reader)
+	devices := make(map[string][3]int64)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		devices[hdr.Name] = [3]int64{int64(hdr.Typeflag), hdr.Devmajor, hdr.Devminor}
+	}
+	assert.Equal(t, map[string][3]int64{
+		"dev/":     {tar.TypeDir, 0, 0},
+		"dev/null": {tar.TypeChar, 1, 3},
+		"dev/sda":  {tar.TypeBlock, 8, 0},
+		"run/fifo": {tar.TypeFifo, 0, 0},
+	}, devices)
+
+	_, err = Squasher{RejectSpecialFiles: true}.Squash(input)
+	assert.EqualError(t, err, `layer contains a character device: "dev/null"`)
+}
+
+func TestSquasherMaxSize(t *testing.T) {
+	t.Parallel()
+
+	input := []ociv1.Layer{
+		TestLayer{
+			{Name: "a", Type: tar.TypeReg},
+			{Name: "b", Type: tar.TypeReg},
+		}.ToLayer(t),
+	}
+	// 2 headers + the 2-block trailer
+	const size = 4 * 512
+
+	layer, err := Squasher{MaxSize: size}.Squash(input)
+	require.NoError(t, err)
+	assert.Equal(t, TestLayer{
+		{Name: "a", Type: tar.TypeReg},
+		{Name: "b", Type: tar.TypeReg},
+	}, ParseTestLayer(t, layer))
+
+	_, err = Squasher{MaxSize: size - 1}.Squash(input)
+	var tooLarge *ErrLayerTooLarge
+	require.True(t, errors.As(err, &tooLarge), "error is %v", err)
+	assert.Equal(t, &ErrLayerTooLarge{Limit: size - 1, Actual: size}, tooLarge)
+	assert.EqualError(t, err, "squashed layer is too large: 2048 bytes uncompressed, but the limit is 2047 bytes")
+}
+
+func TestSquasherCompressor(t *testing.T) {
+	t.Parallel()
+
+	input := []ociv1.Layer{
+		TestLayer{
+			{Name: "dir", Type: tar.TypeDir},
+			{Name: "dir/file", Type: tar.TypeReg},
+		}.ToLayer(t),
+	}
+	expected, err := Squash(input)
+	require.NoError(t, err)
+	expectedDiffID, err := expected.DiffID()
+	require.NoError(t, err)
+
+	var levels []int
+	compressor := func(w io.Writer, level int) (io.WriteCloser, error) {
+		levels = append(levels, level)
+		return klauspostgzip.Compress(w, level)
+	}
+	layer, err := Squasher{Compressor: compressor}.Squash(input)
+	require.NoError(t, err)
+	for _, level := range []int{gzip.BestCompression, gzip.NoCompression} {
+		level := level
+		_, err = Squasher{Compressor: compressor, CompressionLevel: &level}.Squash(input)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []int{gzip.BestSpeed, gzip.BestCompression, gzip.NoCompression}, levels)
+
+	// The Compressor's output is used verbatim as the compressed layer...
+	compressed, err := layer.Compressed()
+	require.NoError(t, err)
+	compressedBytes, err := ioutil.ReadAll(compressed)
+	require.NoError(t, err)
+	require.NoError(t, compressed.Close())
+	digest, _, err := ociv1.SHA256(bytes.NewReader(compressedBytes))
+	require.NoError(t, err)
+	actualDigest, err := layer.Digest()
+	require.NoError(t, err)
+	assert.Equal(t, digest, actualDigest)
+
+	// ... and it has the same content as if go-containerregistry had compressed it.
+	diffID, err := layer.DiffID()
+	require.NoError(t, err)
+	assert.Equal(t, expectedDiffID, diffID)
+	assert.Equal(t, ParseTestLayer(t, expected), ParseTestLayer(t, layer))
+
+	zstdCompressor := func(w io.Writer, level int) (io.WriteCloser, error) {
+		return zstd.NewWriter(w)
+	}
+	_, err = Squasher{Compressor: zstdCompressor}.Squash(input)
+	assert.EqualError(t, err, "compressing layer: Compressor did not produce gzip output")
+}
+
+func TestSquasherCompressionLevel(t *testing.T) {
+	t.Parallel()
+
+	input := []ociv1.Layer{
+		TestLayer{
+			{Name: "dir", Type: tar.TypeDir},
+			{Name: "dir/file", Type: tar.TypeReg},
+		}.ToLayer(t),
+	}
+	readSize := func(t *testing.T, open func() (io.ReadCloser, error)) int64 {
+		t.Helper()
+		reader, err := open()
+		require.NoError(t, err)
+		defer reader.Close()
+		size, err := io.Copy(ioutil.Discard, reader)
+		require.NoError(t, err)
+		return size
+	}
+
+	for name, compressor := range map[string]tarlayer.Compressor{
+		"go-containerregistry": nil,
+		"klauspostgzip":        klauspostgzip.Compress,
+	} {
+		compressor := compressor
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			// The level applies whether or not there is a Compressor; and it takes
+			// precedence over a LayerOption.
+			noCompression, bestCompression := gzip.NoCompression, gzip.BestCompression
+			stored, err := Squasher{Compressor: compressor, CompressionLevel: &noCompression}.Squash(input,
+				ociv1tarball.WithCompressionLevel(gzip.BestCompression))
+			require.NoError(t, err)
+			compressed, err := Squasher{Compressor: compressor, CompressionLevel: &bestCompression}.Squash(input)
+			require.NoError(t, err)
+
+			uncompressedSize := readSize(t, stored.Uncompressed)
+			assert.Greater(t, readSize(t, stored.Compressed), uncompressedSize)
+			assert.Less(t, readSize(t, compressed.Compressed), uncompressedSize)
+		})
+	}
+}
+
+func TestSquasherCompressionLevelRange(t *testing.T) {
+	t.Parallel()
+
+	input := []ociv1.Layer{TestLayer{{Name: "file", Type: tar.TypeReg}}.ToLayer(t)}
+	for _, compressor := range []tarlayer.Compressor{nil, klauspostgzip.Compress} {
+		for _, level := range []int{gzip.HuffmanOnly, gzip.DefaultCompression, gzip.NoCompression, gzip.BestCompression} {
+			level := level
+			_, err := Squasher{Compressor: compressor, CompressionLevel: &level}.Squash(input)
+			assert.NoError(t, err, "level %d", level)
+		}
+		for _, level := range []int{-3, 10, 22} {
+			level := level
+			_, err := Squasher{Compressor: compressor, CompressionLevel: &level}.Squash(input)
+			assert.EqualError(t, err, fmt.Sprintf(
+				"CompressionLevel: invalid gzip compression level %d: must be between -2 and 9", level))
+		}
+	}
+}
+
+// BenchmarkSquasherCompressionLevel shows the speed/size tradeoff of different compression
+// levels, on a layer of text (this package's source code); the "compressed-bytes" metric is the
+// size of the compressed layer.
+func BenchmarkSquasherCompressionLevel(b *testing.B) {
+	filenames, err := filepath.Glob("*.go")
+	require.NoError(b, err)
+	var entries []TestEntry
+	for i := 0; i < 20; i++ {
+		for _, filename := range filenames {
+			body, err := ioutil.ReadFile(filename)
+			require.NoError(b, err)
+			entries = append(entries, TestEntry{
+				Header: &tar.Header{Name: fmt.Sprintf("%d/%s", i, filename), Typeflag: tar.TypeReg, Mode: 0644},
+				Body:   string(body),
+			})
+		}
+	}
+	uncompressed := EntriesToTar(b, entries...)
+	input := []ociv1.Layer{BytesToLayer(b, uncompressed)}
+
+	for _, compressor := range []struct {
+		Name       string
+		Compressor tarlayer.Compressor
+	}{
+		{"compress-gzip", nil},
+		{"klauspostgzip", klauspostgzip.Compress},
+	} {
+		for _, level := range []int{gzip.BestSpeed, gzip.DefaultCompression, gzip.BestCompression} {
+			level := level
+			squasher := Squasher{Compressor: compressor.Compressor, CompressionLevel: &level}
+			b.Run(fmt.Sprintf("%s/level=%d", compressor.Name, level), func(b *testing.B) {
+				b.SetBytes(int64(len(uncompressed)))
+				var size int64
+				for i := 0; i < b.N; i++ {
+					layer, err := squasher.Squash(input)
+					require.NoError(b, err)
+					compressed, err := layer.Compressed()
+					require.NoError(b, err)
+					size, err = io.Copy(ioutil.Discard, compressed)
+					require.NoError(b, err)
+					require.NoError(b, compressed.Close())
+				}
+				b.ReportMetric(float64(size), "compressed-bytes")
+			})
+		}
+	}
+}
+
+func TestSquasherWhiteouts(t *testing.T) {
+	t.Parallel()
+
+	input := []ociv1.Layer{
+		TestLayer{
+			{Name: "a/", Type: tar.TypeDir},
+			{Name: "a/x", Type: tar.TypeReg},
+			{Name: "a/.wh.gone", Type: tar.TypeReg},
+			{Name: "b/", Type: tar.TypeDir},
+			{Name: "b/.wh..wh..opq", Type: tar.TypeReg},
+			{Name: "b/y", Type: tar.TypeReg},
+		}.ToLayer(t),
+	}
+	layer, err := Squasher{WhiteoutPrefix: ".deleted.", OpaqueMarker: ".opaque"}.Squash(input)
+	require.NoError(t, err)
+	assert.Equal(t, TestLayer{
+		{Name: "a/", Type: tar.TypeDir},
+		{Name: "a/.deleted.gone", Type: tar.TypeReg},
+		{Name: "a/x", Type: tar.TypeReg},
+		{Name: "b/", Type: tar.TypeDir},
+		{Name: "b/.opaque", Type: tar.TypeReg},
+		{Name: "b/y", Type: tar.TypeReg},
+	}, ParseTestLayer(t, layer))
+
+	// Files that aren't whiteouts in the input mustn't become whiteouts in the output.
+	for _, name := range []string{"a/.deleted.keepme", "a/.opaque"} {
+		clash := []ociv1.Layer{
+			TestLayer{
+				{Name: "a/", Type: tar.TypeDir},
+				{Name: name, Type: tar.TypeReg},
+			}.ToLayer(t),
+		}
+		_, err = Squasher{WhiteoutPrefix: ".deleted.", OpaqueMarker: ".opaque"}.Squash(clash)
+		assert.EqualError(t, err, `layer contains a file that would be read as a whiteout marker with WhiteoutPrefix ".deleted." and OpaqueMarker ".opaque": "`+name+`"`)
+		// ... but they're fine in the OCI format.
+		_, err = Squash(clash)
+		assert.NoError(t, err)
+	}
+
+	// The OCI marker is fine with a different prefix, and vice-versa.
+	_, err = Squasher{WhiteoutPrefix: ".deleted."}.Squash(input)
+	assert.NoError(t, err)
+	_, err = Squasher{OpaqueMarker: ".opaque"}.Squash(input)
+	assert.NoError(t, err)
+
+	invalid := map[string]struct {
+		Squasher    Squasher
+		ExpectedErr string
+	}{
+		"prefix-slash":      {Squasher{WhiteoutPrefix: "a/b"}, `invalid WhiteoutPrefix: "a/b"`},
+		"prefix-dot":        {Squasher{WhiteoutPrefix: "."}, `invalid WhiteoutPrefix: "."`},
+		"prefix-dotdot":     {Squasher{WhiteoutPrefix: ".."}, `invalid WhiteoutPrefix: ".."`},
+		"opaque-dotdot":     {Squasher{OpaqueMarker: ".."}, `invalid OpaqueMarker: ".."`},
+		"opaque-is-prefix":  {Squasher{WhiteoutPrefix: ".deleted.", OpaqueMarker: ".deleted."}, `OpaqueMarker ".deleted." is ambiguous with WhiteoutPrefix ".deleted."`},
+		"opaque-has-prefix": {Squasher{WhiteoutPrefix: ".deleted.", OpaqueMarker: ".deleted.opq"}, `OpaqueMarker ".deleted.opq" is ambiguous with WhiteoutPrefix ".deleted."`},
+		"opaque-has-oci":    {Squasher{OpaqueMarker: ".wh.opaque"}, `OpaqueMarker ".wh.opaque" is ambiguous with WhiteoutPrefix ".wh."`},
+	}
+	for tcName, tc := range invalid {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			_, err := tc.Squasher.Squash(input)
+			assert.EqualError(t, err, tc.ExpectedErr)
+		})
+	}
+}
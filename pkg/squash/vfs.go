@@ -2,11 +2,39 @@ package squash
 
 import (
 	"archive/tar"
+	"fmt"
 	"path"
 	"sort"
 	"strings"
 )
 
+// The OCI whiteout format, which fsfile uses internally regardless of what the output uses.
+const (
+	ociWhiteoutPrefix = ".wh."
+	ociOpaqueMarker   = ".wh..wh..opq"
+)
+
+// A whiteoutFormat says how to name whiteout markers when writing a layer.
+type whiteoutFormat struct {
+	Prefix string
+	Opaque string
+}
+
+// name translates the name of a file from the OCI whiteout format to this format.  ok is false
+// if the file isn't a whiteout marker, but would be read as one in this format.
+func (wf whiteoutFormat) name(name string) (_ string, ok bool) {
+	switch {
+	case name == ociOpaqueMarker:
+		return wf.Opaque, true
+	case strings.HasPrefix(name, ociWhiteoutPrefix):
+		return wf.Prefix + strings.TrimPrefix(name, ociWhiteoutPrefix), true
+	case name == wf.Opaque || strings.HasPrefix(name, wf.Prefix):
+		return "", false
+	default:
+		return name, true
+	}
+}
+
 type fsfile struct {
 	name     string
 	parent   *fsfile
@@ -16,7 +44,22 @@ type fsfile struct {
 	body   []byte
 }
 
-func fsGet(dir *fsfile, pathname string) *fsfile {
+// maxSymlinks mimics Linux's MAXSYMLINKS; it is the most symlinks that will be followed while
+// resolving a single path, so that a symlink loop is an error rather than infinite recursion.
+const maxSymlinks = 40
+
+func fsGet(dir *fsfile, pathname string) (*fsfile, error) {
+	symlinksLeft := maxSymlinks
+	ret := fsLookup(dir, pathname, &symlinksLeft)
+	if symlinksLeft < 0 {
+		return nil, fmt.Errorf("resolving %q: too many levels of symbolic links", pathname)
+	}
+	return ret, nil
+}
+
+// fsLookup is the guts of fsGet; it returns nil (and sets symlinksLeft to -1) if it runs out of
+// symlinks to follow.
+func fsLookup(dir *fsfile, pathname string, symlinksLeft *int) *fsfile {
 	pathname = path.Clean(pathname)
 
 	// handle absolute paths
@@ -33,16 +76,16 @@ func fsGet(dir *fsfile, pathname string) *fsfile {
 		if slash < 0 {
 			break
 		}
-		dir = dir.Get(pathname[:slash])
+		dir = dir.get(pathname[:slash], symlinksLeft)
 		if dir == nil {
 			return nil
 		}
 		pathname = pathname[slash+1:]
 	}
-	return dir.Get(pathname)
+	return dir.get(pathname, symlinksLeft)
 }
 
-func (f *fsfile) Get(child string) *fsfile {
+func (f *fsfile) get(child string, symlinksLeft *int) *fsfile {
 	var ret *fsfile
 
 	switch child {
@@ -51,32 +94,20 @@ func (f *fsfile) Get(child string) *fsfile {
 	case ".":
 		ret = f
 	default:
-		// Accessing "foo/bar" implies that "foo" is a directory; if it isn't, then white it
-		// out.
-		if f.header != nil && f.header.Typeflag != tar.TypeDir {
-			f.header = nil
-			f.body = nil
-			f.Get(".wh..wh..opq").Set(&tar.Header{
-				Typeflag: tar.TypeReg,
-				Mode:     0644,
-			}, nil)
-		}
-		// Look up the child
-		if f.children == nil {
-			f.children = make(map[string]*fsfile)
-		}
-		if _, ok := f.children[child]; !ok {
-			f.children[child] = &fsfile{
-				name:   child,
-				parent: f,
-			}
-		}
-		ret = f.children[child]
+		ret = f.child(child)
 	}
 
 	// Resolve symlinks
 	for ret != nil && ret.header != nil && ret.header.Typeflag == tar.TypeSymlink {
-		target := fsGet(f, ret.header.Linkname)
+		if *symlinksLeft == 0 {
+			*symlinksLeft = -1
+			return nil
+		}
+		*symlinksLeft--
+		target := fsLookup(f, ret.header.Linkname, symlinksLeft)
+		if *symlinksLeft < 0 {
+			return nil
+		}
 		if target == nil {
 			break
 		}
@@ -87,6 +118,31 @@ func (f *fsfile) Get(child string) *fsfile {
 	return ret
 }
 
+// child returns the direct child of f with the given name (creating it if it doesn't exist yet),
+// without resolving symlinks.
+func (f *fsfile) child(name string) *fsfile {
+	// Accessing "foo/bar" implies that "foo" is a directory; if it isn't, then white it out.
+	if f.header != nil && f.header.Typeflag != tar.TypeDir {
+		f.header = nil
+		f.body = nil
+		f.child(".wh..wh..opq").Set(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+		}, nil)
+	}
+	// Look up the child
+	if f.children == nil {
+		f.children = make(map[string]*fsfile)
+	}
+	if _, ok := f.children[name]; !ok {
+		f.children[name] = &fsfile{
+			name:   name,
+			parent: f,
+		}
+	}
+	return f.children[name]
+}
+
 func (f *fsfile) Set(hdr *tar.Header, body []byte) {
 	if hdr != nil {
 		_hdr := *hdr
@@ -108,7 +164,7 @@ func (f *fsfile) Set(hdr *tar.Header, body []byte) {
 		// make a potential previous implicit whiteout explicit.  I say "potential" because
 		// without the entire layer stack (which this function explicitly doesn't require),
 		// we can't know if any given file was such a dir-to-non-dir conversion.
-		f.Get(".wh..wh..opq").Set(&tar.Header{
+		f.child(".wh..wh..opq").Set(&tar.Header{
 			Typeflag: tar.TypeReg,
 			Mode:     0644,
 		}, nil)
@@ -130,8 +186,13 @@ func (f *fsfile) Set(hdr *tar.Header, body []byte) {
 	}
 }
 
-func (f *fsfile) WriteTo(basedir string, w *tar.Writer) error {
-	name := path.Join(basedir, f.name)
+func (f *fsfile) WriteTo(basedir string, w *tar.Writer, format whiteoutFormat) error {
+	outName, ok := format.name(f.name)
+	if !ok {
+		return fmt.Errorf("layer contains a file that would be read as a whiteout marker with WhiteoutPrefix %q and OpaqueMarker %q: %q",
+			format.Prefix, format.Opaque, path.Join(basedir, f.name))
+	}
+	name := path.Join(basedir, outName)
 
 	if f.header != nil {
 		if f.header.Typeflag == tar.TypeDir {
@@ -167,7 +228,7 @@ func (f *fsfile) WriteTo(basedir string, w *tar.Writer) error {
 
 	for _, childName := range childNames {
 		child := f.children[childName]
-		if err := child.WriteTo(name, w); err != nil {
+		if err := child.WriteTo(name, w, format); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,86 @@
+// Package tarlayer turns tarballs that are generated in memory in to OCI layers.
+package tarlayer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	ociv1 "github.com/google/go-containerregistry/pkg/v1"
+	ociv1tarball "github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// A Compressor returns a writer that compresses to w at the given level; the compressed stream
+// must be complete once the writer has been closed.
+//
+// go-containerregistry only recognizes gzip as an already-compressed layer, so the output must
+// be in gzip format.  Any gzip implementation will do; see the klauspostgzip subpackage for one.
+type Compressor func(w io.Writer, level int) (io.WriteCloser, error)
+
+var magicGzip = []byte{0x1f, 0x8b}
+
+// Compression levels, as in compress/gzip; every gzip implementation accepts at least these.
+const (
+	MinLevel = gzip.HuffmanOnly
+	MaxLevel = gzip.BestCompression
+)
+
+// CheckLevel returns an error if level isn't a gzip compression level, so that a bad level can
+// be caught before doing the work of generating a layer.
+func CheckLevel(level int) error {
+	if level < MinLevel || level > MaxLevel {
+		return fmt.Errorf("invalid gzip compression level %d: must be between %d and %d", level, MinLevel, MaxLevel)
+	}
+	return nil
+}
+
+// Build calls write to generate an uncompressed layer tarball, and wraps the result in to a
+// Layer.  The tarball is held in memory, so that the Layer can be read as many times as needed.
+// If write returns an error, then Build returns that error.
+//
+// The Layer is compressed by go-containerregistry, with compress/gzip.
+func Build(write func(w io.Writer) error, opts ...ociv1tarball.LayerOption) (ociv1.Layer, error) {
+	var byteWriter bytes.Buffer
+	if err := write(&byteWriter); err != nil {
+		return nil, err
+	}
+	return fromBytes(byteWriter.Bytes(), opts)
+}
+
+// BuildCompressed is like Build, but compresses the Layer at the given level.  If compress is
+// nil, then that is done by go-containerregistry (as with ociv1tarball.WithCompressionLevel);
+// otherwise it is done by the Compressor, and any compression-related opts are ignored.
+func BuildCompressed(write func(w io.Writer) error, compress Compressor, level int, opts ...ociv1tarball.LayerOption) (ociv1.Layer, error) {
+	if err := CheckLevel(level); err != nil {
+		return nil, err
+	}
+	if compress == nil {
+		return Build(write, append(opts[:len(opts):len(opts)], ociv1tarball.WithCompressionLevel(level))...)
+	}
+	var byteWriter bytes.Buffer
+	compressWriter, err := compress(&byteWriter, level)
+	if err != nil {
+		return nil, fmt.Errorf("compressing layer: %w", err)
+	}
+	if err := write(compressWriter); err != nil {
+		return nil, err
+	}
+	if err := compressWriter.Close(); err != nil {
+		return nil, fmt.Errorf("compressing layer: %w", err)
+	}
+	// Otherwise go-containerregistry would take the compressed bytes as the uncompressed
+	// tarball, and compress them again.
+	if !bytes.HasPrefix(byteWriter.Bytes(), magicGzip) {
+		return nil, fmt.Errorf("compressing layer: Compressor did not produce gzip output")
+	}
+	return fromBytes(byteWriter.Bytes(), opts)
+}
+
+// fromBytes wraps an in-memory tarball (compressed or not) in to a Layer.
+func fromBytes(byteSlice []byte, opts []ociv1tarball.LayerOption) (ociv1.Layer, error) {
+	return ociv1tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(byteSlice)), nil
+	}, opts...)
+}